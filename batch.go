@@ -0,0 +1,107 @@
+package cargo
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchOptions controls how DownloadBatch runs its downloads.
+type BatchOptions struct {
+	// The maximum number of downloads running at once. Defaults to 4.
+	Concurrency int
+
+	// Optional maximum number of downloads running at once for each host, keyed
+	// by the Source's host (including the port). A host at its limit doesn't
+	// block downloads from other hosts. By default only Concurrency applies.
+	PerHostLimit int
+}
+
+// BatchResult is the result of a single download in a batch.
+type BatchResult struct {
+	Output *DownloadOutput
+	Err    error
+}
+
+// DownloadBatch runs the downloads concurrently, and returns once all of them
+// have completed. The results are in the same order as the inputs. A failed
+// download doesn't stop the others; canceling the context fails any downloads
+// that haven't completed.
+func DownloadBatch(ctx context.Context, inputs []DownloadInput, opts BatchOptions) []BatchResult {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultBatchConcurrency
+	}
+
+	results := make([]BatchResult, len(inputs))
+	slots := make(chan struct{}, opts.Concurrency)
+	hosts := &hostLimiter{limit: opts.PerHostLimit}
+
+	var wg sync.WaitGroup
+	for i := range inputs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var host string
+			if inputs[i].Source != nil {
+				host = inputs[i].Source.Host
+			}
+
+			// The host slot is acquired first, so a download waiting on a busy host
+			// doesn't hold one of the batch's slots.
+			release, err := hosts.acquire(ctx, host)
+			if err != nil {
+				results[i].Err = err
+				return
+			}
+			defer release()
+
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				results[i].Err = ctx.Err()
+				return
+			}
+			defer func() { <-slots }()
+
+			results[i].Output, results[i].Err = Download(ctx, inputs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	return results
+}
+
+const defaultBatchConcurrency = 4
+
+// hostLimiter bounds the number of concurrent downloads for each host. A zero
+// limit doesn't bound anything.
+type hostLimiter struct {
+	limit int
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func (h *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	if h.limit <= 0 {
+		return func() {}, nil
+	}
+
+	h.mu.Lock()
+	if h.slots == nil {
+		h.slots = make(map[string]chan struct{})
+	}
+	slots, ok := h.slots[host]
+	if !ok {
+		slots = make(chan struct{}, h.limit)
+		h.slots[host] = slots
+	}
+	h.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
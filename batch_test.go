@@ -0,0 +1,105 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// concurrencyServer records the highest number of requests it served at once.
+type concurrencyServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	active int
+	max    int
+}
+
+func newConcurrencyServer() *concurrencyServer {
+	s := &concurrencyServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.active++
+		if s.active > s.max {
+			s.max = s.active
+		}
+		s.mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		s.mu.Lock()
+		s.active--
+		s.mu.Unlock()
+
+		w.Write([]byte(r.URL.Path))
+	}))
+	return s
+}
+
+func (s *concurrencyServer) maxActive() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.max
+}
+
+func TestDownloadBatch(t *testing.T) {
+	t.Run(`given a per host limit`, func(t *testing.T) {
+		serverA := newConcurrencyServer()
+		defer serverA.Close()
+		serverB := newConcurrencyServer()
+		defer serverB.Close()
+
+		var inputs []cargo.DownloadInput
+		var dests []*bytes.Buffer
+		for i := 0; i < 8; i++ {
+			for _, server := range []*concurrencyServer{serverA, serverB} {
+				source, _ := url.Parse(server.URL + `/file`)
+				dest := &bytes.Buffer{}
+
+				inputs = append(inputs, cargo.DownloadInput{Source: source, Dest: dest})
+				dests = append(dests, dest)
+			}
+		}
+
+		results := cargo.DownloadBatch(context.Background(), inputs, cargo.BatchOptions{
+			Concurrency:  8,
+			PerHostLimit: 2,
+		})
+
+		require.Len(t, results, len(inputs))
+		for i, result := range results {
+			require.NoError(t, result.Err)
+
+			assert.Equal(t, int64(5), result.Output.FileSize)
+			assert.Equal(t, `/file`, dests[i].String())
+		}
+
+		assert.Equal(t, 2, serverA.maxActive())
+		assert.Equal(t, 2, serverB.maxActive())
+	})
+
+	t.Run(`given a failed download`, func(t *testing.T) {
+		server := newConcurrencyServer()
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		results := cargo.DownloadBatch(context.Background(), []cargo.DownloadInput{
+			{Source: source, Dest: &bytes.Buffer{}},
+			{Source: source},
+		}, cargo.BatchOptions{})
+
+		assert.NoError(t, results[0].Err)
+		assert.ErrorIs(t, results[1].Err, cargo.ErrMissingDest)
+	})
+}
@@ -0,0 +1,86 @@
+package cargo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotModified is the error returned when the server reports that the
+// resource hasn't changed since it was last downloaded. The destination is left
+// untouched.
+var ErrNotModified = errors.New(`not modified`)
+
+// conditional reports if the input makes conditional requests, where a 304 Not
+// Modified response is expected.
+func (in DownloadInput) conditional() bool {
+	return in.Cache != nil || !in.IfModifiedSince.IsZero()
+}
+
+// Cache stores the ETags of downloaded resources, keyed by URL, so unchanged
+// resources don't need to be downloaded again. Implementations must be safe for
+// concurrent use.
+type Cache interface {
+	// Get returns the ETag stored for the URL.
+	Get(url string) (etag string, ok bool)
+
+	// Set stores the ETag for the URL.
+	Set(url, etag string)
+}
+
+func updateCache(in DownloadInput, resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		in.Cache.Set(in.Source.String(), etag)
+	}
+}
+
+// FileCache is a Cache that stores each ETag in a file in Dir. The directory is
+// created when the first ETag is stored. Failures to read or write the files
+// are treated as a missing entry, since the cache is only an optimization.
+type FileCache struct {
+	Dir string
+}
+
+// Get implements Cache.
+func (c *FileCache) Get(url string) (string, bool) {
+	data, err := os.ReadFile(c.path(url))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(data)), true
+}
+
+// Set implements Cache.
+func (c *FileCache) Set(url, etag string) {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return
+	}
+
+	tmpFile, err := os.CreateTemp(c.Dir, "etag-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.WriteString(etag)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+
+	os.Rename(tmpFile.Name(), c.path(url))
+}
+
+func (c *FileCache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:]))
+}
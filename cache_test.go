@@ -0,0 +1,55 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(`If-None-Match`) == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set(`ETag`, `"v1"`)
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+	cache := &cargo.FileCache{Dir: t.TempDir()}
+
+	in := cargo.DownloadInput{
+		Source:           source,
+		Cache:            cache,
+		ValidateResponse: cargo.ValidateStatusCodeEqual(http.StatusOK),
+	}
+
+	var first bytes.Buffer
+	in.Dest = &first
+
+	_, err := cargo.Download(context.Background(), in)
+
+	require.NoError(t, err)
+
+	etag, ok := cache.Get(server.URL)
+	assert.True(t, ok)
+	assert.Equal(t, `"v1"`, etag)
+
+	var second bytes.Buffer
+	in.Dest = &second
+
+	_, err = cargo.Download(context.Background(), in)
+
+	assert.ErrorIs(t, err, cargo.ErrNotModified)
+	assert.Equal(t, `hello`, first.String())
+	assert.Zero(t, second.Len())
+}
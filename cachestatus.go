@@ -0,0 +1,115 @@
+package cargo
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheResult is whether a response was served by a cache between Cargo and
+// the origin, such as a CDN.
+type CacheResult string
+
+// The results reported in CacheStatus.
+const (
+	CacheResultUnknown CacheResult = ""
+	CacheResultHit     CacheResult = "hit"
+	CacheResultMiss    CacheResult = "miss"
+)
+
+// CacheStatus is the cache information reported by a response's headers, for
+// example to tell a slow download caused by a cache miss from a slow transfer.
+type CacheStatus struct {
+	// The result, from the Cache-Status, CF-Cache-Status, or X-Cache header, in
+	// that order. A response with none of them is a hit if its Age is positive.
+	Result CacheResult
+
+	// The value of the header the Result was parsed from, as sent.
+	Header string
+
+	// The time the response spent in a cache, from the Age header. It is 0 when
+	// the header is missing or invalid.
+	Age time.Duration
+}
+
+// parseCacheStatus reads the cache information from the response's headers.
+func parseCacheStatus(h http.Header) CacheStatus {
+	var status CacheStatus
+	if seconds, err := strconv.ParseInt(strings.TrimSpace(h.Get("Age")), 10, 64); err == nil && seconds > 0 {
+		status.Age = time.Duration(seconds) * time.Second
+	}
+
+	parsers := []struct {
+		header string
+		parse  func(string) CacheResult
+	}{
+		{"Cache-Status", parseCacheStatusHeader},
+		{"CF-Cache-Status", parseCloudflareCacheStatus},
+		{"X-Cache", parseXCache},
+	}
+	for _, p := range parsers {
+		value := h.Get(p.header)
+		if value == "" {
+			continue
+		}
+		if result := p.parse(value); result != CacheResultUnknown {
+			status.Result = result
+			status.Header = value
+			return status
+		}
+	}
+
+	if status.Age > 0 {
+		status.Result = CacheResultHit
+	}
+	return status
+}
+
+// parseCacheStatusHeader parses the standard Cache-Status header (RFC 9211),
+// a list of the caches that handled the response, each with a "hit" parameter
+// or a "fwd" parameter giving the reason it was forwarded. A hit in any cache
+// means the origin wasn't reached.
+func parseCacheStatusHeader(value string) CacheResult {
+	result := CacheResultUnknown
+	for _, entry := range strings.Split(value, ",") {
+		for _, param := range strings.Split(entry, ";")[1:] {
+			param = strings.ToLower(strings.TrimSpace(param))
+			switch {
+			case param == "hit" || param == "hit=?1":
+				return CacheResultHit
+			case strings.HasPrefix(param, "fwd="):
+				result = CacheResultMiss
+			}
+		}
+	}
+	return result
+}
+
+// parseCloudflareCacheStatus parses Cloudflare's CF-Cache-Status header. The
+// stale and revalidated statuses were still served from the cache.
+func parseCloudflareCacheStatus(value string) CacheResult {
+	switch strings.ToUpper(strings.TrimSpace(value)) {
+	case "HIT", "STALE", "UPDATING", "REVALIDATED":
+		return CacheResultHit
+	case "MISS", "EXPIRED", "BYPASS", "DYNAMIC":
+		return CacheResultMiss
+	}
+	return CacheResultUnknown
+}
+
+// parseXCache parses the X-Cache header used by Varnish, Fastly, CloudFront,
+// and Squid, such as "HIT", "Miss from cloudfront", or "MISS, HIT" for layered
+// caches. A hit in any layer means the origin wasn't reached.
+func parseXCache(value string) CacheResult {
+	result := CacheResultUnknown
+	for _, entry := range strings.Split(strings.ToUpper(value), ",") {
+		switch {
+		case strings.Contains(entry, "HIT"):
+			return CacheResultHit
+		case strings.Contains(entry, "MISS"):
+			result = CacheResultMiss
+		}
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadCacheStatus(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.Write([]byte(`Hello World`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	download := func(h http.Header) cargo.CacheStatus {
+		header = h
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   &bytes.Buffer{},
+		})
+		require.NoError(t, err)
+		return out.CacheStatus
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   cargo.CacheStatus
+	}{
+		{`no headers`, nil, cargo.CacheStatus{}},
+		{
+			`a Cache-Status hit`,
+			http.Header{`Cache-Status`: {`OriginCache; fwd=uri-miss, CDN; hit; ttl=30`}},
+			cargo.CacheStatus{Result: cargo.CacheResultHit, Header: `OriginCache; fwd=uri-miss, CDN; hit; ttl=30`},
+		},
+		{
+			`a Cache-Status miss`,
+			http.Header{`Cache-Status`: {`CDN; fwd=uri-miss`}},
+			cargo.CacheStatus{Result: cargo.CacheResultMiss, Header: `CDN; fwd=uri-miss`},
+		},
+		{
+			`a CF-Cache-Status`,
+			http.Header{`Cf-Cache-Status`: {`EXPIRED`}, `Age`: {`0`}},
+			cargo.CacheStatus{Result: cargo.CacheResultMiss, Header: `EXPIRED`},
+		},
+		{
+			`an X-Cache from layered caches`,
+			http.Header{`X-Cache`: {`MISS, HIT`}, `Age`: {`120`}},
+			cargo.CacheStatus{Result: cargo.CacheResultHit, Header: `MISS, HIT`, Age: 2 * time.Minute},
+		},
+		{
+			`a CloudFront X-Cache`,
+			http.Header{`X-Cache`: {`Miss from cloudfront`}},
+			cargo.CacheStatus{Result: cargo.CacheResultMiss, Header: `Miss from cloudfront`},
+		},
+		{
+			`only an Age`,
+			http.Header{`Age`: {`30`}},
+			cargo.CacheStatus{Result: cargo.CacheResultHit, Age: 30 * time.Second},
+		},
+		{
+			`an unrecognized X-Cache`,
+			http.Header{`X-Cache`: {`uncacheable`}},
+			cargo.CacheStatus{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(`given `+tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, download(tt.header))
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package cargo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func benchmarkCopyToFile(b *testing.B, copy func(context.Context, *os.File, *os.File) (int64, error)) {
+	dir := b.TempDir()
+
+	src, err := os.Create(filepath.Join(dir, `src`))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer src.Close()
+
+	if _, err := src.Write(bytes.Repeat([]byte{0xAB}, 64*1024*1024)); err != nil {
+		b.Fatal(err)
+	}
+
+	dst, err := os.Create(filepath.Join(dir, `dst`))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer dst.Close()
+
+	b.SetBytes(64 * 1024 * 1024)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		src.Seek(0, io.SeekStart)
+		dst.Seek(0, io.SeekStart)
+		dst.Truncate(0)
+
+		if _, err := copy(context.Background(), dst, src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCopyWithContext(b *testing.B) {
+	benchmarkCopyToFile(b, func(ctx context.Context, dst, src *os.File) (int64, error) {
+		return copyWithContext(ctx, dst, src)
+	})
+}
+
+func BenchmarkReadFromWithContext(b *testing.B) {
+	benchmarkCopyToFile(b, func(ctx context.Context, dst, src *os.File) (int64, error) {
+		return readFromWithContext(ctx, dst, src)
+	})
+}
+
+func TestReadFromWithContext(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), readFromChunkSize/5)
+
+	t.Run(`given a reader larger than a chunk`, func(t *testing.T) {
+		var buf bytes.Buffer
+
+		n, err := readFromWithContext(context.Background(), &buf, bytes.NewReader(data))
+
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(len(data)), n)
+		assert.Equal(t, data, buf.Bytes())
+	})
+
+	t.Run(`given a canceled context`, func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := readFromWithContext(ctx, &bytes.Buffer{}, bytes.NewReader(data))
+
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestFullJitterBackoff(t *testing.T) {
+	backoff := fullJitterBackoff(time.Second)
+
+	for retry := 1; retry <= 4; retry++ {
+		max := time.Second << (retry - 1)
+
+		for i := 0; i < 100; i++ {
+			delay := backoff(retry)
+
+			assert.GreaterOrEqual(t, delay, time.Duration(0))
+			assert.LessOrEqual(t, delay, max)
+		}
+	}
+}
+
+func TestExtendedLengthPath(t *testing.T) {
+	assert.Equal(t, `\\?\C:\scratch\deep\dir`, extendedLengthPath(`C:\scratch/deep\dir`))
+	assert.Equal(t, `\\?\UNC\server\share\dir`, extendedLengthPath(`\\server\share\dir`))
+	assert.Equal(t, `\\?\C:\dir`, extendedLengthPath(`\\?\C:\dir`))
+}
+
+func TestIsWindowsReservedName(t *testing.T) {
+	for _, name := range []string{`CON`, `nul`, `Aux.txt`, `COM1`, `lpt9.tar.gz`, `PRN. `} {
+		assert.True(t, isWindowsReservedName(name), name)
+	}
+	for _, name := range []string{`cargo-download-123`, `CONFIG`, `COM0`, `COM10`, `LPT`, `NUL1`} {
+		assert.False(t, isWindowsReservedName(name), name)
+	}
+}
+
+func TestParseNetrc(t *testing.T) {
+	entries := parseNetrc(`# comment
+machine one.example login a password b
+macdef init
+	cd /pub
+	machine ignored.example login x password y
+
+machine two.example
+	login c
+	password d
+default login e password f
+`)
+
+	assert.Equal(t, []netrcEntry{
+		{machine: `one.example`, login: `a`, password: `b`},
+		{machine: `two.example`, login: `c`, password: `d`},
+		{login: `e`, password: `f`},
+	}, entries)
+}
+
+func TestSplitRangesBySize(t *testing.T) {
+	t.Run(`given a size that isn't evenly divisible`, func(t *testing.T) {
+		assert.Equal(t, []byteRange{{0, 3}, {4, 7}, {8, 9}}, splitRangesBySize(10, 4))
+	})
+
+	t.Run(`given an evenly divisible size`, func(t *testing.T) {
+		assert.Equal(t, []byteRange{{0, 4}, {5, 9}}, splitRangesBySize(10, 5))
+	})
+
+	t.Run(`given a chunk size larger than the file`, func(t *testing.T) {
+		assert.Equal(t, []byteRange{{0, 9}}, splitRangesBySize(10, 64))
+	})
+}
+
+func TestNewHTTPClientIdleConns(t *testing.T) {
+	t.Run(`given no idle options`, func(t *testing.T) {
+		transport := newHTTPClient(DownloadInput{ForceHTTP2: true}).Transport.(*http2OnlyTransport).RoundTripper.(*http.Transport)
+
+		assert.Equal(t, defaultMaxIdleConns, transport.MaxIdleConns)
+		assert.Equal(t, defaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+		assert.Equal(t, defaultIdleConnTimeout, transport.IdleConnTimeout)
+	})
+
+	t.Run(`given idle options`, func(t *testing.T) {
+		in := DownloadInput{MaxIdleConns: 500, MaxIdleConnsPerHost: 50, IdleConnTimeout: time.Minute}
+		require.True(t, in.buildsClient())
+
+		transport := newHTTPClient(in).Transport.(*http.Transport)
+
+		assert.Equal(t, 500, transport.MaxIdleConns)
+		assert.Equal(t, 50, transport.MaxIdleConnsPerHost)
+		assert.Equal(t, time.Minute, transport.IdleConnTimeout)
+	})
+}
+
+func TestVerifyCopy(t *testing.T) {
+	dest, err := os.Create(filepath.Join(t.TempDir(), `dest`))
+	require.NoError(t, err)
+	defer dest.Close()
+
+	_, err = dest.Write([]byte(`hellp`))
+	require.NoError(t, err)
+
+	err = verifyCopy(context.Background(), dest, bytes.NewReader([]byte(`hello`)), 5)
+
+	var verifyErr *CopyVerificationError
+	require.ErrorAs(t, err, &verifyErr)
+	assert.Equal(t, dest.Name(), verifyErr.Path)
+
+	_, err = dest.Seek(-1, io.SeekCurrent)
+	require.NoError(t, err)
+	_, err = dest.Write([]byte(`o`))
+	require.NoError(t, err)
+
+	assert.NoError(t, verifyCopy(context.Background(), dest, bytes.NewReader([]byte(`hello`)), 5))
+}
+
+// fakeClock is a clock that only moves when it is advanced.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	c     chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) clockTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, other := range t.clock.timers {
+		if other == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// advance moves the clock forward, firing every timer that is due.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+		} else {
+			t.c <- c.now
+		}
+	}
+	c.timers = pending
+}
+
+// waitForTimers waits until at least n timers are pending, so the clock isn't
+// advanced before the code under test has started waiting on it.
+func (c *fakeClock) waitForTimers(t *testing.T, n int) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		return len(c.timers) >= n
+	}, time.Second, time.Millisecond)
+}
+
+func TestWithTimeoutClock(t *testing.T) {
+	t.Run(`given the deadline passes`, func(t *testing.T) {
+		clock := newFakeClock()
+
+		ctx, cancel := withTimeout(context.Background(), clock, time.Hour)
+		defer cancel()
+
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.Equal(t, clock.Now().Add(time.Hour), deadline)
+
+		clock.advance(time.Hour - time.Second)
+		assert.NoError(t, ctx.Err())
+
+		clock.advance(time.Second)
+		<-ctx.Done()
+		assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+	})
+
+	t.Run(`given the parent is canceled`, func(t *testing.T) {
+		parent, parentCancel := context.WithCancel(context.Background())
+
+		ctx, cancel := withTimeout(parent, newFakeClock(), time.Hour)
+		defer cancel()
+
+		parentCancel()
+		<-ctx.Done()
+		assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	})
+
+	t.Run(`given the parent has an earlier deadline`, func(t *testing.T) {
+		clock := newFakeClock()
+
+		parent, parentCancel := context.WithDeadline(context.Background(), clock.Now().Add(time.Minute))
+		defer parentCancel()
+
+		ctx, cancel := withTimeout(parent, clock, time.Hour)
+		defer cancel()
+
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.Equal(t, clock.Now().Add(time.Minute), deadline)
+	})
+}
+
+func TestReadWithRetryClock(t *testing.T) {
+	clock := newFakeClock()
+	in := DownloadInput{
+		Retry: &RetryPolicy{
+			MaxAttempts: 2,
+			Backoff:     func(int) time.Duration { return time.Hour },
+		},
+		clock: clock,
+	}
+
+	var attempts int
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := readWithRetry(context.Background(), in, func(context.Context) (int64, *http.Response, error) {
+			attempts++
+			if attempts == 1 {
+				return 0, nil, errors.New(`connection reset`)
+			}
+			return 0, nil, nil
+		}, nil)
+		result <- err
+	}()
+
+	clock.waitForTimers(t, 1)
+	clock.advance(time.Hour)
+
+	assert.NoError(t, <-result)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestRateLimiterClock(t *testing.T) {
+	clock := newFakeClock()
+
+	limiter := NewRateLimiter(100)
+	limiter.clock = clock
+	limiter.last = clock.Now()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- limiter.WaitN(context.Background(), 200)
+	}()
+
+	clock.waitForTimers(t, 1)
+	select {
+	case <-result:
+		t.Fatal("WaitN returned before the clock advanced")
+	default:
+	}
+
+	clock.advance(time.Second)
+	assert.NoError(t, <-result)
+}
+
+func TestThroughputMonitorClock(t *testing.T) {
+	clock := newFakeClock()
+
+	canceled := make(chan struct{})
+	monitor := startThroughputMonitor(MinThroughput{BytesPerSecond: 100, Window: 10 * time.Second}, clock, func() {
+		close(canceled)
+	})
+
+	for i := 0; i < 10; i++ {
+		monitor.Write(make([]byte, 50))
+
+		clock.waitForTimers(t, 1)
+		clock.advance(time.Second)
+	}
+
+	<-canceled
+
+	var slowErr *SlowDownloadError
+	require.ErrorAs(t, monitor.stop(), &slowErr)
+	assert.Equal(t, int64(50), slowErr.BytesPerSecond)
+}
@@ -1,13 +1,23 @@
 package cargo_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -45,6 +55,452 @@ func TestDownload(t *testing.T) {
 	})
 }
 
+func TestDownloadSink(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 10000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given a sink`, func(t *testing.T) {
+		var received []byte
+
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Sink: func(b []byte) error {
+				received = append(received, b...)
+				return nil
+			},
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(len(data)), out.FileSize)
+		assert.Equal(t, data, received)
+	})
+
+	t.Run(`given a sink that fails`, func(t *testing.T) {
+		sinkErr := errors.New(`sink failed`)
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Sink: func(b []byte) error {
+				return sinkErr
+			},
+		})
+
+		assert.ErrorIs(t, err, sinkErr)
+	})
+
+	t.Run(`given a buffered sink`, func(t *testing.T) {
+		var received []byte
+
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Sink: func(b []byte) error {
+				time.Sleep(time.Millisecond)
+				received = append(received, b...)
+				return nil
+			},
+			SinkBuffer: 4,
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(len(data)), out.FileSize)
+		assert.Equal(t, data, received)
+	})
+
+	t.Run(`given a buffered sink that fails`, func(t *testing.T) {
+		sinkErr := errors.New(`sink failed`)
+
+		var written int64
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Sink: func(b []byte) error {
+				return sinkErr
+			},
+			SinkBuffer: 4,
+			OnFailure: func(n int64) error {
+				written = n
+				return nil
+			},
+		})
+
+		assert.ErrorIs(t, err, sinkErr)
+		assert.Equal(t, int64(0), written)
+	})
+}
+
+func TestDownloadChecksumWriter(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 10000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source:         source,
+		Dest:           &bytes.Buffer{},
+		ChecksumWriter: sha256.New(),
+	})
+
+	require.NoError(t, err)
+
+	expected := sha256.Sum256(data)
+	assert.Equal(t, expected[:], out.Checksum)
+}
+
+func TestDownloadExpectedSize(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 1000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get(`header`) != `` {
+			w.Header().Set(`X-Content-Length`, fmt.Sprint(len(data)))
+		}
+		w.(http.Flusher).Flush()
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	download := func(t *testing.T, rawurl string, size int64) int64 {
+		source, _ := url.Parse(rawurl)
+
+		var expected int64
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:       source,
+			Dest:         &bytes.Buffer{},
+			ExpectedSize: size,
+			ProgressHandler: cargo.ProgressHandlerFunc(func(ex, received int64) {
+				// Only record the value given to Expected, not the final total.
+				if received == 0 {
+					expected = ex
+				}
+			}),
+		})
+
+		require.NoError(t, err)
+
+		return expected
+	}
+
+	t.Run(`given a chunked response`, func(t *testing.T) {
+		assert.Equal(t, int64(-1), download(t, server.URL, 0))
+	})
+
+	t.Run(`given a chunked response with a X-Content-Length`, func(t *testing.T) {
+		assert.Equal(t, int64(len(data)), download(t, server.URL+`?header=1`, 0))
+	})
+
+	t.Run(`given an expected size`, func(t *testing.T) {
+		assert.Equal(t, int64(len(data)), download(t, server.URL, int64(len(data))))
+	})
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (fn writerFunc) Write(b []byte) (int, error) {
+	return fn(b)
+}
+
+func TestDownloadCancelCleanup(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 10000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	tests := map[string]func(context.CancelFunc, *cargo.DownloadInput){
+		`before request`: func(cancel context.CancelFunc, in *cargo.DownloadInput) {
+			cancel()
+		},
+		`after response`: func(cancel context.CancelFunc, in *cargo.DownloadInput) {
+			in.ValidateResponse = func(*http.Response) error {
+				cancel()
+				return nil
+			}
+		},
+		`mid-read`: func(cancel context.CancelFunc, in *cargo.DownloadInput) {
+			in.ProgressHandler = cargo.ProgressHandlerFunc(func(_, received int64) {
+				if received > 0 {
+					cancel()
+				}
+			})
+		},
+		`mid-copy`: func(cancel context.CancelFunc, in *cargo.DownloadInput) {
+			in.Dest = writerFunc(func(b []byte) (int, error) {
+				cancel()
+				return len(b), nil
+			})
+		},
+	}
+
+	for name, setup := range tests {
+		t.Run(`given a cancel `+name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv(`TMPDIR`, dir)
+			t.Setenv(`TMP`, dir)
+			t.Setenv(`TEMP`, dir)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			in := cargo.DownloadInput{Source: source, Dest: &bytes.Buffer{}}
+			setup(cancel, &in)
+
+			_, err := cargo.Download(ctx, in)
+
+			assert.ErrorIs(t, err, context.Canceled)
+
+			matches, _ := filepath.Glob(filepath.Join(dir, `cargo-download-*`))
+			assert.Empty(t, matches)
+		})
+	}
+}
+
+func TestHTTPResponseError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("access denied\n"))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source:           source,
+		Dest:             &bytes.Buffer{},
+		ValidateResponse: cargo.ValidateStatusCodeEqual(http.StatusOK),
+	})
+
+	var respErr *cargo.HTTPResponseError
+	require.True(t, errors.As(err, &respErr))
+
+	assert.Equal(t, http.StatusForbidden, respErr.StatusCode)
+	assert.Equal(t, `403 Forbidden`, respErr.Status)
+	assert.Equal(t, []byte("access denied\n"), respErr.Body)
+	assert.Equal(t, `http response error (Forbidden): access denied`, respErr.Error())
+}
+
+func TestHTTPResponseErrorBodyLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("access denied\n"))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	tests := []struct {
+		name  string
+		limit int64
+		body  []byte
+	}{
+		{name: `given a smaller limit`, limit: 6, body: []byte(`access`)},
+		{name: `given a larger limit`, limit: 1 << 20, body: []byte("access denied\n")},
+		{name: `given no limit`, limit: 0, body: []byte("access denied\n")},
+		{name: `given a negative limit`, limit: -1, body: nil},
+	}
+
+	download := func(limit int64) *cargo.HTTPResponseError {
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:           source,
+			Dest:             &bytes.Buffer{},
+			ValidateResponse: cargo.ValidateStatusCodeEqual(http.StatusOK),
+			ErrorBodyLimit:   limit,
+		})
+
+		var respErr *cargo.HTTPResponseError
+		require.True(t, errors.As(err, &respErr))
+
+		return respErr
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.body, download(tt.limit).Body)
+		})
+	}
+
+	t.Run(`given no limit and a default limit`, func(t *testing.T) {
+		cargo.SetDefaultErrorBodyLimit(3)
+		defer cargo.SetDefaultErrorBodyLimit(0)
+
+		assert.Equal(t, []byte(`acc`), download(0).Body)
+		assert.Equal(t, []byte(`access`), download(6).Body)
+	})
+}
+
+func TestDownloadRejectHTMLSniff(t *testing.T) {
+	binary := bytes.Repeat([]byte{0x00, 0x01, 0x02, 0xff}, 1000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Type`, `application/octet-stream`)
+		if r.URL.Path == `/portal` {
+			w.Write([]byte(`<!DOCTYPE html><html><body>Sign in to continue</body></html>`))
+			return
+		}
+		w.Write(binary)
+	}))
+	defer server.Close()
+
+	t.Run(`given an html body`, func(t *testing.T) {
+		source, _ := url.Parse(server.URL + `/portal`)
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:          source,
+			Dest:            &bytes.Buffer{},
+			RejectHTMLSniff: true,
+		})
+
+		var htmlErr *cargo.HTMLResponseError
+		require.True(t, errors.As(err, &htmlErr))
+
+		assert.Equal(t, `text/html; charset=utf-8`, htmlErr.ContentType)
+	})
+
+	t.Run(`given a binary body`, func(t *testing.T) {
+		source, _ := url.Parse(server.URL)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:          source,
+			Dest:            &buf,
+			RejectHTMLSniff: true,
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, binary, buf.Bytes())
+	})
+}
+
+func TestDownloadTempPattern(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given a pattern and directory`, func(t *testing.T) {
+		dir := t.TempDir()
+
+		var staged []string
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest: writerFunc(func(b []byte) (int, error) {
+				staged, _ = filepath.Glob(filepath.Join(dir, `myapp-release-*`))
+				return len(b), nil
+			}),
+			TempDir:     dir,
+			TempPattern: `myapp-release-*`,
+		})
+
+		require.NoError(t, err)
+
+		assert.Len(t, staged, 1)
+	})
+
+	t.Run(`given a pattern with a path separator`, func(t *testing.T) {
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:      source,
+			Dest:        &bytes.Buffer{},
+			TempPattern: `nested/download-*`,
+		})
+
+		assert.ErrorIs(t, err, cargo.ErrInvalidTempPattern)
+	})
+}
+
+func TestDownloadCompressDest(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 10000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	var buf bytes.Buffer
+
+	out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source:       source,
+		Dest:         &buf,
+		CompressDest: true,
+	})
+
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(len(data)), out.FileSize)
+	assert.Equal(t, int64(len(data)), out.BytesReceived)
+	assert.Equal(t, int64(buf.Len()), out.BytesWritten)
+	assert.Less(t, buf.Len(), len(data))
+
+	r, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+
+	decompressed, _ := io.ReadAll(r)
+	assert.Equal(t, data, decompressed)
+}
+
+func TestDownloadMissingInput(t *testing.T) {
+	source, _ := url.Parse(`https://example.com`)
+
+	t.Run(`given no source`, func(t *testing.T) {
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{Dest: &bytes.Buffer{}})
+
+		assert.ErrorIs(t, err, cargo.ErrMissingSource)
+	})
+
+	t.Run(`given no destination`, func(t *testing.T) {
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{Source: source})
+
+		assert.ErrorIs(t, err, cargo.ErrMissingDest)
+	})
+}
+
+func TestDownloadURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	t.Run(`given a valid url`, func(t *testing.T) {
+		var buf bytes.Buffer
+
+		_, err := cargo.DownloadURL(context.Background(), server.URL, &buf, func(in *cargo.DownloadInput) {
+			in.ValidateResponse = cargo.ValidateStatusCodeEqual(http.StatusAccepted)
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, `hello`, buf.String())
+	})
+
+	t.Run(`given an invalid url`, func(t *testing.T) {
+		_, err := cargo.DownloadURL(context.Background(), "http://exa mple.com/\x7f", &bytes.Buffer{})
+
+		var urlErr *url.Error
+		require.True(t, errors.As(err, &urlErr))
+
+		assert.Contains(t, err.Error(), `invalid download url`)
+	})
+}
+
 func ExampleDownload() {
 	source, _ := url.Parse(`https://...`)
 
@@ -82,3 +538,876 @@ func tempFilePath(parts ...string) string {
 
 	return path
 }
+
+func TestDownloadStagingFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`Hello World`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	staging, err := os.CreateTemp(t.TempDir(), `staging`)
+	require.NoError(t, err)
+	defer staging.Close()
+
+	_, err = staging.Write([]byte(`stale data from a previous run`))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = cargo.Download(context.Background(), cargo.DownloadInput{
+		Source:      source,
+		Dest:        &buf,
+		StagingFile: staging,
+		TempDir:     `/does/not/exist`,
+	})
+
+	require.NoError(t, err)
+
+	assert.Equal(t, `Hello World`, buf.String())
+
+	staged, err := os.ReadFile(staging.Name())
+	require.NoError(t, err)
+	assert.Equal(t, `Hello World`, string(staged))
+}
+
+func TestDownloadCopyHooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`Hello World`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given hooks that rewrite the staged file`, func(t *testing.T) {
+		var staged string
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   &buf,
+			BeforeCopy: func(f *os.File) error {
+				data, err := io.ReadAll(f)
+				if err != nil {
+					return err
+				}
+				_, err = f.WriteAt(bytes.ToUpper(data), 0)
+				return err
+			},
+			AfterCopy: func(f *os.File) {
+				staged = f.Name()
+			},
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, `HELLO WORLD`, buf.String())
+		assert.NotEmpty(t, staged)
+		assert.NoFileExists(t, staged)
+	})
+
+	t.Run(`given a failing BeforeCopy hook`, func(t *testing.T) {
+		hookErr := errors.New(`rejected`)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   &buf,
+			BeforeCopy: func(f *os.File) error {
+				return hookErr
+			},
+		})
+
+		assert.ErrorIs(t, err, hookErr)
+		assert.Equal(t, 0, buf.Len())
+	})
+}
+
+func TestDownloadStripBOM(t *testing.T) {
+	bom := []byte{0xEF, 0xBB, 0xBF}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case `/bom`:
+			// Split the BOM across writes, so it arrives in separate reads.
+			w.Write(bom[:1])
+			w.(http.Flusher).Flush()
+			w.Write(bom[1:])
+			w.Write([]byte(`key = value`))
+		case `/middle`:
+			w.Write([]byte(`key`))
+			w.Write(bom)
+		case `/short`:
+			w.Write(bom[:2])
+		}
+	}))
+	defer server.Close()
+
+	download := func(path string) (*cargo.DownloadOutput, []byte) {
+		source, _ := url.Parse(server.URL + path)
+
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:   source,
+			Dest:     &buf,
+			StripBOM: true,
+		})
+		require.NoError(t, err)
+
+		return out, buf.Bytes()
+	}
+
+	t.Run(`given a leading BOM`, func(t *testing.T) {
+		out, data := download(`/bom`)
+
+		assert.Equal(t, `key = value`, string(data))
+		assert.Equal(t, int64(11), out.FileSize)
+	})
+
+	t.Run(`given a BOM after the start`, func(t *testing.T) {
+		_, data := download(`/middle`)
+
+		assert.Equal(t, append([]byte(`key`), bom...), data)
+	})
+
+	t.Run(`given a body shorter than a BOM`, func(t *testing.T) {
+		_, data := download(`/short`)
+
+		assert.Equal(t, bom[:2], data)
+	})
+}
+
+func TestDownloadDetachCopyTimeout(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 10000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	download := func(detach bool) (*bytes.Buffer, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+		defer cancel()
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(ctx, cargo.DownloadInput{
+			Source: source,
+			Dest: writerFunc(func(b []byte) (int, error) {
+				// A slow destination, that outlasts the parent's deadline.
+				time.Sleep(100 * time.Millisecond)
+				return buf.Write(b)
+			}),
+			DetachCopyTimeout: detach,
+		})
+		return &buf, err
+	}
+
+	t.Run(`given an attached copy`, func(t *testing.T) {
+		_, err := download(false)
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run(`given a detached copy`, func(t *testing.T) {
+		buf, err := download(true)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+	})
+}
+
+func TestDownloadValidateStaged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	errInvalid := errors.New(`invalid archive`)
+
+	download := func(path string) (*bytes.Buffer, error) {
+		source, _ := url.Parse(server.URL + path)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   &buf,
+			ValidateStaged: func(f *os.File) error {
+				data, err := io.ReadAll(f)
+				if err != nil {
+					return err
+				}
+				if !bytes.HasSuffix(data, []byte(`.zip`)) {
+					return errInvalid
+				}
+				return nil
+			},
+		})
+		return &buf, err
+	}
+
+	t.Run(`given a valid staged file`, func(t *testing.T) {
+		buf, err := download(`/archive.zip`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, `/archive.zip`, buf.String())
+	})
+
+	t.Run(`given an invalid staged file`, func(t *testing.T) {
+		buf, err := download(`/archive.tar`)
+
+		assert.ErrorIs(t, err, errInvalid)
+		assert.Equal(t, 0, buf.Len())
+	})
+}
+
+func TestDownloadContentLength(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 1000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`X-File-Size`, fmt.Sprint(len(data)))
+		w.(http.Flusher).Flush()
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	var expected int64
+
+	_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source: source,
+		Dest:   &bytes.Buffer{},
+		ContentLength: func(resp *http.Response) int64 {
+			size, err := strconv.ParseInt(resp.Header.Get(`X-File-Size`), 10, 64)
+			if err != nil {
+				return -1
+			}
+			return size
+		},
+		ProgressHandler: cargo.ProgressHandlerFunc(func(ex, received int64) {
+			if received == 0 {
+				expected = ex
+			}
+		}),
+	})
+
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(len(data)), expected)
+}
+
+func TestDownloadTruncated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, _ := w.(http.Hijacker).Hijack()
+		defer conn.Close()
+
+		switch r.URL.Path {
+		case `/chunked`:
+			// A single chunk, without the terminating zero-length chunk.
+			buf.WriteString("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n")
+		case `/length`:
+			buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello")
+		}
+		buf.Flush()
+	}))
+	defer server.Close()
+
+	download := func(path string) (*bytes.Buffer, error) {
+		source, _ := url.Parse(server.URL + path)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   &buf,
+		})
+		return &buf, err
+	}
+
+	t.Run(`given a chunked response missing the final chunk`, func(t *testing.T) {
+		buf, err := download(`/chunked`)
+
+		var truncErr *cargo.TruncatedError
+		require.True(t, errors.As(err, &truncErr))
+
+		assert.Equal(t, int64(5), truncErr.Received)
+		assert.Equal(t, int64(-1), truncErr.Expected)
+		assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+		assert.Equal(t, 0, buf.Len())
+	})
+
+	t.Run(`given a response shorter than its Content-Length`, func(t *testing.T) {
+		_, err := download(`/length`)
+
+		var truncErr *cargo.TruncatedError
+		require.True(t, errors.As(err, &truncErr))
+
+		assert.Equal(t, int64(5), truncErr.Received)
+		assert.Equal(t, int64(10), truncErr.Expected)
+	})
+}
+
+func TestDownloadDryRun(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 1000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given a matching checksum`, func(t *testing.T) {
+		sum := sha256.Sum256(data)
+
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:           source,
+			Dest:             &buf,
+			DryRun:           true,
+			ExpectedChecksum: sum[:],
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(len(data)), out.FileSize)
+		assert.Equal(t, sum[:], out.Checksum)
+		assert.Equal(t, 0, buf.Len())
+	})
+
+	t.Run(`given a mismatched checksum`, func(t *testing.T) {
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:           source,
+			DryRun:           true,
+			ExpectedChecksum: make([]byte, sha256.Size),
+		})
+
+		var checksumErr *cargo.ChecksumError
+		assert.True(t, errors.As(err, &checksumErr))
+	})
+
+	t.Run(`given a file path`, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), `data.txt`)
+
+		_, err := cargo.DownloadToFile(context.Background(), path, cargo.DownloadInput{
+			Source: source,
+			DryRun: true,
+		})
+
+		require.NoError(t, err)
+
+		assert.NoFileExists(t, path)
+	})
+}
+
+// syncCloseRecorder records the calls to its Sync and Close methods.
+type syncCloseRecorder struct {
+	bytes.Buffer
+	synced, closed bool
+}
+
+func (r *syncCloseRecorder) Sync() error {
+	r.synced = true
+	return nil
+}
+
+func (r *syncCloseRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestDownloadSyncCloseDest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == `/missing` {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given neither option`, func(t *testing.T) {
+		dest := &syncCloseRecorder{}
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   dest,
+		})
+
+		require.NoError(t, err)
+
+		assert.False(t, dest.synced)
+		assert.False(t, dest.closed)
+	})
+
+	t.Run(`given both options`, func(t *testing.T) {
+		dest := &syncCloseRecorder{}
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:    source,
+			Dest:      dest,
+			SyncDest:  true,
+			CloseDest: true,
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, `hello`, dest.String())
+		assert.True(t, dest.synced)
+		assert.True(t, dest.closed)
+	})
+
+	t.Run(`given a failed download`, func(t *testing.T) {
+		missing, _ := url.Parse(server.URL + `/missing`)
+
+		dest := &syncCloseRecorder{}
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:           missing,
+			Dest:             dest,
+			ValidateResponse: cargo.ValidateStatusCodeEqual(http.StatusOK),
+			SyncDest:         true,
+			CloseDest:        true,
+		})
+
+		require.Error(t, err)
+
+		assert.False(t, dest.synced)
+		assert.True(t, dest.closed)
+	})
+}
+
+func TestDownloadRemoteAddr(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source: source,
+		Dest:   &bytes.Buffer{},
+	})
+
+	require.NoError(t, err)
+
+	assert.Equal(t, server.Listener.Addr().String(), out.RemoteAddr)
+}
+
+func TestDownloadDecompress(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 1000)
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(data)
+	zw.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Encoding`, `gzip`)
+		w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	download := func(header http.Header, decompress bool) []byte {
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:     source,
+			Dest:       &buf,
+			Header:     header,
+			Decompress: decompress,
+		})
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+
+	manual := http.Header{`Accept-Encoding`: []string{`gzip`}}
+
+	t.Run(`given the transport decompresses the body`, func(t *testing.T) {
+		assert.Equal(t, data, download(nil, true))
+	})
+
+	t.Run(`given a manual Accept-Encoding`, func(t *testing.T) {
+		assert.Equal(t, data, download(manual, true))
+	})
+
+	t.Run(`given a manual Accept-Encoding without Decompress`, func(t *testing.T) {
+		assert.Equal(t, compressed.Bytes(), download(manual, false))
+	})
+
+	t.Run(`given the byte counts`, func(t *testing.T) {
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:     source,
+			Dest:       &buf,
+			Header:     manual,
+			Decompress: true,
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(compressed.Len()), out.BytesReceived)
+		assert.Equal(t, int64(len(data)), out.BytesWritten)
+		assert.Equal(t, int64(len(data)), out.FileSize)
+	})
+}
+
+func TestDownloadDecompressEncodings(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 1000)
+
+	var deflated bytes.Buffer
+	zw := zlib.NewWriter(&deflated)
+	zw.Write(data)
+	zw.Close()
+
+	cargo.RegisterDecoder(`X-Test-Base64`, func(r io.Reader) (io.Reader, error) {
+		return base64.NewDecoder(base64.StdEncoding, r), nil
+	})
+
+	bodies := map[string][]byte{
+		`deflate`:       deflated.Bytes(),
+		`x-test-base64`: []byte(base64.StdEncoding.EncodeToString(data)),
+		`x-unknown`:     data,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.TrimPrefix(r.URL.Path, `/`)
+		w.Header().Set(`Content-Encoding`, encoding)
+		w.Write(bodies[encoding])
+	}))
+	defer server.Close()
+
+	download := func(encoding string) []byte {
+		source, _ := url.Parse(server.URL + `/` + encoding)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:     source,
+			Dest:       &buf,
+			Header:     http.Header{`Accept-Encoding`: []string{encoding}},
+			Decompress: true,
+		})
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+
+	t.Run(`given a deflate body`, func(t *testing.T) {
+		assert.Equal(t, data, download(`deflate`))
+	})
+
+	t.Run(`given a registered encoding`, func(t *testing.T) {
+		assert.Equal(t, data, download(`x-test-base64`))
+	})
+
+	t.Run(`given an unknown encoding`, func(t *testing.T) {
+		assert.Equal(t, data, download(`x-unknown`))
+	})
+}
+
+func TestDownloadExpectDecompressedSize(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 1000)
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(data)
+	zw.Close()
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set(`Content-Encoding`, `gzip`)
+		w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	download := func(expected int64) ([]byte, error) {
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:                 source,
+			Dest:                   &buf,
+			Header:                 http.Header{`Accept-Encoding`: []string{`gzip`}},
+			Decompress:             true,
+			ExpectDecompressedSize: expected,
+		})
+		return buf.Bytes(), err
+	}
+
+	t.Run(`given the expected size`, func(t *testing.T) {
+		out, err := download(int64(len(data)))
+		require.NoError(t, err)
+		assert.Equal(t, data, out)
+	})
+
+	t.Run(`given a different size`, func(t *testing.T) {
+		requests = 0
+
+		out, err := download(int64(len(data)) + 1)
+
+		var sizeErr *cargo.DecompressedSizeError
+		require.ErrorAs(t, err, &sizeErr)
+		assert.Equal(t, int64(len(data))+1, sizeErr.Expected)
+		assert.Equal(t, int64(len(data)), sizeErr.Actual)
+		assert.Equal(t, cargo.ErrorClassIntegrity, cargo.ClassifyError(err))
+		assert.Empty(t, out)
+		assert.Equal(t, 1, requests)
+	})
+}
+
+func TestDownloadPhaseHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	var phases []string
+
+	_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source: source,
+		Dest:   &bytes.Buffer{},
+		PhaseHandler: func(p cargo.Phase) {
+			phases = append(phases, p.String())
+		},
+	})
+
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{`connecting`, `response`, `downloading`, `verifying`, `finalizing`}, phases)
+}
+
+func TestDownloadEmptyFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Length`, `0`)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given an empty file`, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), `empty.txt`)
+
+		var updates [][2]int64
+		out, err := cargo.DownloadToFile(context.Background(), path, cargo.DownloadInput{
+			Source: source,
+			ProgressHandler: cargo.ProgressHandlerFunc(func(expected, received int64) {
+				updates = append(updates, [2]int64{expected, received})
+			}),
+		})
+
+		require.NoError(t, err)
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(0), info.Size())
+		assert.Equal(t, int64(0), out.FileSize)
+		assert.Equal(t, [][2]int64{{0, 0}}, updates)
+	})
+
+	t.Run(`given a minimum size`, func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:   source,
+			Dest:     &buf,
+			MinBytes: 1,
+		})
+
+		assert.True(t, errors.Is(err, cargo.ErrBelowMinBytes))
+		assert.Equal(t, 0, buf.Len())
+	})
+}
+
+func TestDownloadOnFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == `/missing` {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`hello world`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given a partial write to Dest`, func(t *testing.T) {
+		var buf bytes.Buffer
+		var written int64 = -1
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   cargo.LimitedWriter(&buf, 5),
+			OnFailure: func(n int64) error {
+				written = n
+				buf.Reset()
+				return nil
+			},
+		})
+
+		var limitErr *cargo.ExceededLimitError
+		assert.True(t, errors.As(err, &limitErr))
+		assert.Equal(t, int64(5), written)
+		assert.Equal(t, 0, buf.Len())
+	})
+
+	t.Run(`given a failure before Dest is written`, func(t *testing.T) {
+		missing, _ := url.Parse(server.URL + `/missing`)
+		var written int64 = -1
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:           missing,
+			Dest:             &bytes.Buffer{},
+			ValidateResponse: cargo.ValidateStatusCodeEqual(http.StatusOK),
+			OnFailure: func(n int64) error {
+				written = n
+				return nil
+			},
+		})
+
+		require.Error(t, err)
+		assert.Equal(t, int64(0), written)
+	})
+
+	t.Run(`given a sink and a failing hook`, func(t *testing.T) {
+		sinkErr := errors.New(`sink failed`)
+		hookErr := errors.New(`rollback failed`)
+		var written int64 = -1
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Sink: func(b []byte) error {
+				return sinkErr
+			},
+			OnFailure: func(n int64) error {
+				written = n
+				return hookErr
+			},
+		})
+
+		assert.ErrorIs(t, err, sinkErr)
+		assert.ErrorIs(t, err, hookErr)
+		assert.Equal(t, int64(0), written)
+
+		var failureErr *cargo.OnFailureError
+		require.True(t, errors.As(err, &failureErr))
+		assert.Equal(t, hookErr, failureErr.HookErr)
+	})
+}
+
+func TestDownloadVerifyCopy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte(`0123456789`), 1000))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given a file`, func(t *testing.T) {
+		f, err := os.Create(filepath.Join(t.TempDir(), `data`))
+		require.NoError(t, err)
+		defer f.Close()
+
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:     source,
+			Dest:       f,
+			VerifyCopy: true,
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, int64(10000), out.FileSize)
+	})
+
+	t.Run(`given a file opened for appending`, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), `data`)
+		require.NoError(t, os.WriteFile(path, []byte(`existing`), 0600))
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+		require.NoError(t, err)
+		defer f.Close()
+
+		_, err = cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:     source,
+			Dest:       f,
+			VerifyCopy: true,
+		})
+
+		require.NoError(t, err)
+	})
+}
+
+func TestValidateBodyPrefix(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 1000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == `/error` {
+			w.Write([]byte(`{"error":"quota exceeded"}`))
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	errQuota := errors.New(`quota exceeded`)
+	validate := cargo.ValidateBodyPrefix(64, func(prefix []byte) error {
+		if bytes.HasPrefix(prefix, []byte(`{"error"`)) {
+			return errQuota
+		}
+		return nil
+	})
+
+	t.Run(`given a valid body`, func(t *testing.T) {
+		source, _ := url.Parse(server.URL)
+
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:           source,
+			Dest:             &buf,
+			ValidateResponse: validate,
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+		assert.Equal(t, int64(len(data)), out.FileSize)
+	})
+
+	t.Run(`given an invalid body`, func(t *testing.T) {
+		source, _ := url.Parse(server.URL + `/error`)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:           source,
+			Dest:             &buf,
+			ValidateResponse: validate,
+		})
+
+		assert.ErrorIs(t, err, errQuota)
+		assert.Equal(t, 0, buf.Len())
+	})
+
+	t.Run(`given a negative length`, func(t *testing.T) {
+		source, _ := url.Parse(server.URL + `/error`)
+
+		var called bool
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   &buf,
+			ValidateResponse: cargo.ValidateBodyPrefix(-1, func([]byte) error {
+				called = true
+				return errQuota
+			}),
+		})
+
+		require.NoError(t, err)
+
+		assert.False(t, called)
+		assert.Equal(t, `{"error":"quota exceeded"}`, buf.String())
+	})
+}
@@ -0,0 +1,193 @@
+package cargo
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrMissingContentMD5 is the error returned when DownloadInput.RequireContentMD5
+// is set and the response doesn't include a Content-MD5 header.
+var ErrMissingContentMD5 = errors.New(`missing Content-MD5 header`)
+
+// ChecksumError is the error returned when the digest of the downloaded data
+// doesn't match the expected digest.
+type ChecksumError struct {
+	Algorithm string // The name of the hash algorithm, e.g. "md5"
+	Expected  []byte
+	Actual    []byte
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch (%s): expected %x, got %x", e.Algorithm, e.Expected, e.Actual)
+}
+
+// contentMD5Verifier computes the MD5 of the data written through it, to be
+// compared against the response's Content-MD5 header.
+type contentMD5Verifier struct {
+	expected []byte
+	hash     hash.Hash
+}
+
+// newContentMD5Verifier returns a verifier for the response, or nil if the
+// input doesn't verify the Content-MD5 header or the response doesn't have one.
+func newContentMD5Verifier(in DownloadInput, resp *http.Response) (*contentMD5Verifier, error) {
+	if !in.VerifyContentMD5 && !in.RequireContentMD5 {
+		return nil, nil
+	}
+
+	header := resp.Header.Get("Content-MD5")
+	if header == "" {
+		if in.RequireContentMD5 {
+			return nil, ErrMissingContentMD5
+		}
+		return nil, nil
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-MD5 header: %w", err)
+	}
+
+	return &contentMD5Verifier{expected: expected, hash: md5.New()}, nil
+}
+
+func (v *contentMD5Verifier) wrap(r io.Reader) io.Reader {
+	return io.TeeReader(r, v.hash)
+}
+
+func (v *contentMD5Verifier) verify() error {
+	actual := v.hash.Sum(nil)
+	if !bytes.Equal(actual, v.expected) {
+		return &ChecksumError{Algorithm: "md5", Expected: v.expected, Actual: actual}
+	}
+	return nil
+}
+
+// verifyChecksum compares the ChecksumWriter's digest with the input's
+// ExpectedChecksum, if one is set.
+func verifyChecksum(in DownloadInput) error {
+	if in.ExpectedChecksum == nil || in.ChecksumWriter == nil {
+		return nil
+	}
+	actual := in.ChecksumWriter.Sum(nil)
+	if !bytes.Equal(actual, in.ExpectedChecksum) {
+		return &ChecksumError{Algorithm: hashName(in.ChecksumWriter), Expected: in.ExpectedChecksum, Actual: actual}
+	}
+	return nil
+}
+
+// skipIfChecksumMatches returns the output of a skipped download, if the input
+// sets SkipIfChecksumMatches and the regular file at path already matches its
+// ExpectedChecksum. Any error reading the file is treated as a mismatch. A
+// skipped download is reported to the input's Metrics.
+func skipIfChecksumMatches(in DownloadInput, path string) *DownloadOutput {
+	if !in.SkipIfChecksumMatches || in.ExpectedChecksum == nil || in.ChecksumWriter == nil {
+		return nil
+	}
+
+	startTime := time.Now()
+
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	in.ChecksumWriter.Reset()
+	size, err := io.Copy(in.ChecksumWriter, f)
+	if err != nil || verifyChecksum(in) != nil {
+		in.ChecksumWriter.Reset()
+		return nil
+	}
+
+	out := &DownloadOutput{
+		FileSize: size,
+		Duration: time.Since(startTime),
+		Checksum: in.ChecksumWriter.Sum(nil),
+		Skipped:  true,
+	}
+	observeDownload(in, startTime, 0, out, nil)
+
+	return out
+}
+
+// hashes returns the ChecksumWriter and Hashers set on the input.
+func (in DownloadInput) hashes() []hash.Hash {
+	var hashes []hash.Hash
+	if in.ChecksumWriter != nil {
+		hashes = append(hashes, in.ChecksumWriter)
+	}
+	for _, h := range in.Hashers {
+		if h != nil {
+			hashes = append(hashes, h)
+		}
+	}
+	return hashes
+}
+
+func (in DownloadInput) resetHashes() {
+	for _, h := range in.hashes() {
+		h.Reset()
+	}
+}
+
+// setChecksums records the digests of the input's hashes.
+func (o *DownloadOutput) setChecksums(in DownloadInput) {
+	if in.ChecksumWriter != nil {
+		o.Checksum = in.ChecksumWriter.Sum(nil)
+	}
+
+	hashes := in.hashes()
+	if len(hashes) == 0 {
+		return
+	}
+
+	o.Checksums = make(map[string][]byte, len(hashes))
+	for _, h := range hashes {
+		o.Checksums[hashName(h)] = h.Sum(nil)
+	}
+}
+
+// hashName returns the name of the hash's algorithm. It is derived from the
+// package implementing the hash, and the digest size for packages that provide
+// more than one variant, like crypto/sha512.
+func hashName(h hash.Hash) string {
+	name := strings.TrimPrefix(fmt.Sprintf("%T", h), "*")
+	if idx := strings.IndexByte(name, '.'); idx != -1 {
+		name = name[:idx]
+	}
+
+	switch name {
+	case "sha256":
+		if h.Size() == sha256.Size224 {
+			return "sha224"
+		}
+	case "sha512":
+		switch h.Size() {
+		case sha512.Size224:
+			return "sha512/224"
+		case sha512.Size256:
+			return "sha512/256"
+		case sha512.Size384:
+			return "sha384"
+		}
+	}
+
+	return name
+}
@@ -0,0 +1,235 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadVerifyContentMD5(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 10000)
+	digest := md5.Sum(data)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case `/valid`:
+			w.Header().Set(`Content-MD5`, base64.StdEncoding.EncodeToString(digest[:]))
+		case `/invalid`:
+			w.Header().Set(`Content-MD5`, base64.StdEncoding.EncodeToString(make([]byte, md5.Size)))
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	download := func(path string, in cargo.DownloadInput) error {
+		in.Source, _ = url.Parse(server.URL + path)
+		in.Dest = &bytes.Buffer{}
+
+		_, err := cargo.Download(context.Background(), in)
+		return err
+	}
+
+	t.Run(`given a matching header`, func(t *testing.T) {
+		assert.NoError(t, download(`/valid`, cargo.DownloadInput{VerifyContentMD5: true}))
+	})
+
+	t.Run(`given a mismatched header`, func(t *testing.T) {
+		err := download(`/invalid`, cargo.DownloadInput{VerifyContentMD5: true})
+
+		var checksumErr *cargo.ChecksumError
+		require.True(t, errors.As(err, &checksumErr))
+
+		assert.Equal(t, `md5`, checksumErr.Algorithm)
+		assert.Equal(t, digest[:], checksumErr.Actual)
+	})
+
+	t.Run(`given a missing header`, func(t *testing.T) {
+		assert.NoError(t, download(`/missing`, cargo.DownloadInput{VerifyContentMD5: true}))
+	})
+
+	t.Run(`given a missing header when required`, func(t *testing.T) {
+		assert.ErrorIs(t, download(`/missing`, cargo.DownloadInput{RequireContentMD5: true}), cargo.ErrMissingContentMD5)
+	})
+}
+
+func TestDownloadVerifyDigestTrailer(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 10000)
+	digest := sha256.Sum256(data)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Trailer`, `Digest`)
+		w.Write(data)
+
+		switch r.URL.Path {
+		case `/valid`:
+			w.Header().Set(`Digest`, `unknown=abc, SHA-256=`+base64.StdEncoding.EncodeToString(digest[:]))
+		case `/invalid`:
+			w.Header().Set(`Digest`, `sha-256=`+base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)))
+		}
+	}))
+	defer server.Close()
+
+	download := func(path string) error {
+		source, _ := url.Parse(server.URL + path)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:              source,
+			Dest:                &buf,
+			VerifyDigestTrailer: true,
+		})
+		if err == nil {
+			assert.Equal(t, data, buf.Bytes())
+		}
+		return err
+	}
+
+	t.Run(`given a matching trailer`, func(t *testing.T) {
+		assert.NoError(t, download(`/valid`))
+	})
+
+	t.Run(`given a mismatched trailer`, func(t *testing.T) {
+		err := download(`/invalid`)
+
+		var checksumErr *cargo.ChecksumError
+		require.True(t, errors.As(err, &checksumErr))
+
+		assert.Equal(t, `sha256`, checksumErr.Algorithm)
+		assert.Equal(t, digest[:], checksumErr.Actual)
+	})
+
+	t.Run(`given a missing trailer`, func(t *testing.T) {
+		assert.NoError(t, download(`/missing`))
+	})
+}
+
+func TestDownloadHashers(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 10000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source:         source,
+		Dest:           &bytes.Buffer{},
+		ChecksumWriter: sha256.New(),
+		Hashers:        []hash.Hash{md5.New(), sha512.New384()},
+	})
+
+	require.NoError(t, err)
+
+	md5Sum := md5.Sum(data)
+	sha256Sum := sha256.Sum256(data)
+	sha384Sum := sha512.Sum384(data)
+
+	assert.Equal(t, map[string][]byte{
+		`md5`:    md5Sum[:],
+		`sha256`: sha256Sum[:],
+		`sha384`: sha384Sum[:],
+	}, out.Checksums)
+	assert.Equal(t, sha256Sum[:], out.Checksum)
+}
+
+func TestDownloadSkipIfChecksumMatches(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 1000)
+	sum := sha256.Sum256(data)
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	var metrics *metricsRecorder
+	download := func(t *testing.T, existing []byte) (*cargo.DownloadOutput, []byte) {
+		requests = 0
+		metrics = &metricsRecorder{}
+
+		path := filepath.Join(t.TempDir(), `data.bin`)
+		require.NoError(t, os.WriteFile(path, existing, 0600))
+
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		require.NoError(t, err)
+		defer f.Close()
+
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:                source,
+			Dest:                  f,
+			ExpectedChecksum:      sum[:],
+			SkipIfChecksumMatches: true,
+			Metrics:               metrics,
+		})
+		require.NoError(t, err)
+
+		written, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		return out, written
+	}
+
+	t.Run(`given a file that matches`, func(t *testing.T) {
+		out, written := download(t, data)
+
+		assert.True(t, out.Skipped)
+		assert.Equal(t, 0, requests)
+		assert.Equal(t, int64(len(data)), out.FileSize)
+		assert.Equal(t, sum[:], out.Checksum)
+		assert.Equal(t, data, written)
+
+		require.Len(t, metrics.downloads, 1)
+		assert.True(t, metrics.downloads[0].Skipped)
+		assert.Equal(t, 0, metrics.downloads[0].Attempts)
+		assert.NoError(t, metrics.downloads[0].Err)
+	})
+
+	t.Run(`given a file that doesn't match`, func(t *testing.T) {
+		out, written := download(t, nil)
+
+		assert.False(t, out.Skipped)
+		assert.Equal(t, 1, requests)
+		assert.Equal(t, data, written)
+
+		require.Len(t, metrics.downloads, 1)
+		assert.False(t, metrics.downloads[0].Skipped)
+	})
+
+	t.Run(`given DownloadToFile`, func(t *testing.T) {
+		requests = 0
+
+		path := filepath.Join(t.TempDir(), `data.bin`)
+		require.NoError(t, os.WriteFile(path, data, 0600))
+
+		out, err := cargo.DownloadToFile(context.Background(), path, cargo.DownloadInput{
+			Source:                source,
+			ExpectedChecksum:      sum[:],
+			SkipIfChecksumMatches: true,
+		})
+
+		require.NoError(t, err)
+
+		assert.True(t, out.Skipped)
+		assert.Equal(t, 0, requests)
+	})
+}
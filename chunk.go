@@ -0,0 +1,422 @@
+package cargo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// ChunkAssemblyError is the error returned when the chunks of a parallel
+// download can't be assembled into the complete file, for example when the
+// server responds to a ranged request with a different range than requested.
+type ChunkAssemblyError struct {
+	Offset int64  // The offset in the file where the problem was found
+	Reason string // A description of the problem
+}
+
+func (e *ChunkAssemblyError) Error() string {
+	return fmt.Sprintf("chunk assembly failed at offset %d: %s", e.Offset, e.Reason)
+}
+
+// byteRange is an inclusive range of bytes in a file.
+type byteRange struct {
+	start, end int64
+}
+
+// chunkPlan describes how a download is split into ranged requests, and how
+// many of them are read at once.
+type chunkPlan struct {
+	size    int64
+	ifRange string
+	ranges  []byteRange
+	workers int
+
+	// The Dest the ranges are written directly into, when the input allows it
+	// with WriteChunksToDest.
+	destAt io.WriterAt
+}
+
+// planChunks sends a HEAD request to check if the download can be split into
+// parallel ranged requests. It returns nil when the input doesn't ask for a
+// parallel download, or the server doesn't support one, in which case the
+// download falls back to a single request.
+func planChunks(ctx context.Context, in DownloadInput) *chunkPlan {
+	if (in.Concurrency < 2 && in.ChunkSize <= 0) || in.Sink != nil || in.ResumeFrom != nil {
+		return nil
+	}
+	if in.Method != "" && in.Method != http.MethodGet {
+		return nil
+	}
+	// These options inspect each response body as a whole.
+	if in.VerifyContentMD5 || in.RequireContentMD5 || in.VerifyDigestTrailer || in.RejectHTMLSniff || in.StripBOM || in.Decompress {
+		return nil
+	}
+
+	in.enterPhase(PhaseConnecting)
+
+	req, err := newRequest(ctx, in)
+	if err != nil {
+		return nil
+	}
+	req.Method = http.MethodHead
+
+	resp, err := sendRequest(in, req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	size := in.ContentLength(resp)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" || size <= 0 {
+		return nil
+	}
+	// A file over the limit, of an unexpected size, or rejected by the
+	// ValidateResponse is left to the single request, which fails before
+	// reading the body.
+	if checkMaxBytes(in, size) != nil || validateResponse(in, resp) != nil {
+		return nil
+	}
+
+	validators := &DownloadState{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	plan := &chunkPlan{
+		size:    size,
+		ifRange: validators.ifRange(),
+		workers: in.Concurrency,
+	}
+	if in.ChunkSize > 0 {
+		plan.ranges = splitRangesBySize(size, in.ChunkSize)
+		if plan.workers < 1 {
+			plan.workers = defaultChunkWorkers
+		}
+	} else {
+		plan.ranges = splitRanges(size, int64(in.Concurrency))
+	}
+	return plan
+}
+
+// chunkDestAt returns the input's Dest as an io.WriterAt, when WriteChunksToDest
+// is set and none of the options that need the assembled file staged first are
+// used. The ranges are written at their offsets from the start of Dest, so it
+// also returns nil for a Dest that isn't at its start, or that refuses WriteAt,
+// like a file opened with O_APPEND.
+func chunkDestAt(in DownloadInput) io.WriterAt {
+	if !in.WriteChunksToDest || in.DryRun || in.StagingFile != nil || len(in.hashes()) > 0 {
+		return nil
+	}
+	if in.DestTransform != nil || in.CompressDest || in.VerifyCopy || in.ValidateStaged != nil || in.BeforeCopy != nil || in.AfterCopy != nil {
+		return nil
+	}
+	w, ok := in.Dest.(io.WriterAt)
+	if !ok {
+		return nil
+	}
+	if s, ok := in.Dest.(io.Seeker); ok {
+		if offset, err := s.Seek(0, io.SeekCurrent); err != nil || offset != 0 {
+			return nil
+		}
+	}
+	if _, err := w.WriteAt(nil, 0); err != nil {
+		return nil
+	}
+	return w
+}
+
+// endDestAt truncates the Dest the ranges were written into to the size of the
+// file, dropping anything it held past the end, and moves its offset to the
+// end, as if the file had been written to it in order.
+func endDestAt(dest io.Writer, size int64) error {
+	if t, ok := dest.(truncater); ok {
+		if err := t.Truncate(size); err != nil {
+			return err
+		}
+	}
+	if s, ok := dest.(io.Seeker); ok {
+		if _, err := s.Seek(size, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultChunkWorkers is the number of ranges read at once when ChunkSize is
+// set without a Concurrency.
+const defaultChunkWorkers = 4
+
+// splitRanges splits size bytes into count ranges of roughly equal length.
+func splitRanges(size, count int64) []byteRange {
+	if count > size {
+		count = size
+	}
+
+	ranges := make([]byteRange, count)
+	for i := range ranges {
+		ranges[i] = byteRange{
+			start: int64(i) * size / count,
+			end:   (int64(i)+1)*size/count - 1,
+		}
+	}
+	return ranges
+}
+
+// splitRangesBySize splits size bytes into ranges of chunkSize bytes, the last
+// of which holds the remainder.
+func splitRangesBySize(size, chunkSize int64) []byteRange {
+	ranges := make([]byteRange, 0, (size+chunkSize-1)/chunkSize)
+	for start := int64(0); start < size; start += chunkSize {
+		end := start + chunkSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		ranges = append(ranges, byteRange{start: start, end: end})
+	}
+	return ranges
+}
+
+// chunk is a single ranged request of a parallel download, staged in its own
+// Staging.
+type chunk struct {
+	requested byteRange
+	staging   Staging
+	resp      *http.Response
+
+	// The range reported by the response's Content-Range header, and the number
+	// of bytes actually received.
+	received byteRange
+	total    int64
+	written  int64
+}
+
+// readChunks performs a single attempt of a parallel download, reading the
+// plan's ranges concurrently, up to the plan's number of workers at once, and
+// then assembling them into dst. Each range is staged once its read begins,
+// unless the plan writes the ranges directly into Dest, in which case there is
+// nothing to assemble and dst is unused.
+func readChunks(ctx context.Context, in DownloadInput, plan *chunkPlan, dst io.Writer) (int64, *http.Response, error) {
+	if in.ProgressHandler != nil {
+		expected := in.ExpectedSize
+		if expected <= 0 {
+			expected = plan.size
+		}
+		in.ProgressHandler.Expected(expected)
+	}
+
+	chunks := make([]*chunk, len(plan.ranges))
+	for i, r := range plan.ranges {
+		chunks[i] = &chunk{requested: r}
+	}
+	defer func() {
+		for _, c := range chunks {
+			if c.staging != nil {
+				releaseStaging(c.staging)
+			}
+		}
+	}()
+
+	in.enterPhase(PhaseDownloading)
+
+	readCtx, readCancel := withTimeout(ctx, in.clock, in.ReadTimeout)
+	defer readCancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			readCancel()
+		})
+	}
+
+	workers := make(chan struct{}, plan.workers)
+	for _, c := range chunks {
+		wg.Add(1)
+		go func(c *chunk) {
+			defer wg.Done()
+
+			select {
+			case workers <- struct{}{}:
+				defer func() { <-workers }()
+			case <-readCtx.Done():
+				fail(readCtx.Err())
+				return
+			}
+
+			release, err := in.MemoryBudget.acquire(readCtx)
+			if err != nil {
+				fail(err)
+				return
+			}
+			defer release()
+
+			if plan.destAt == nil {
+				s, err := in.StagingFactory.Create()
+				if err != nil {
+					fail(err)
+					return
+				}
+				c.staging = s
+			}
+
+			if err := readChunk(readCtx, in, plan, c); err != nil {
+				fail(err)
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	resp := chunks[0].resp
+
+	if plan.destAt != nil {
+		var n int64
+		for _, c := range chunks {
+			n += c.written
+		}
+		if firstErr != nil {
+			return n, resp, firstErr
+		}
+		if err := verifyChunks(chunks, plan.size); err != nil {
+			return n, resp, err
+		}
+
+		completeProgress(in.ProgressHandler, n)
+
+		return n, resp, checkMinBytes(in, n)
+	}
+
+	if firstErr != nil {
+		return 0, resp, firstErr
+	}
+
+	if err := verifyChunks(chunks, plan.size); err != nil {
+		return 0, resp, err
+	}
+
+	var n int64
+	for _, c := range chunks {
+		if _, err := c.staging.Seek(0, io.SeekStart); err != nil {
+			return n, resp, err
+		}
+		written, err := copyWithContext(ctx, dst, c.staging)
+		n += written
+		if err != nil {
+			return n, resp, err
+		}
+	}
+
+	completeProgress(in.ProgressHandler, n)
+
+	return n, resp, checkMinBytes(in, n)
+}
+
+// readChunk requests the chunk's range, and reads the response body into the
+// chunk's file, or into Dest at the range's offset.
+func readChunk(ctx context.Context, in DownloadInput, plan *chunkPlan, c *chunk) error {
+	req, err := newRequest(ctx, in)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", c.requested.start, c.requested.end))
+	if plan.ifRange != "" {
+		req.Header.Set("If-Range", plan.ifRange)
+	}
+
+	resp, err := sendRequest(in, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	c.resp = resp
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return &ChunkAssemblyError{
+			Offset: c.requested.start,
+			Reason: fmt.Sprintf("unexpected status %q for a ranged request", resp.Status),
+		}
+	}
+
+	start, end, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+	if !ok || start < 0 {
+		return &ChunkAssemblyError{
+			Offset: c.requested.start,
+			Reason: fmt.Sprintf("invalid Content-Range %q", resp.Header.Get("Content-Range")),
+		}
+	}
+	c.received = byteRange{start: start, end: end}
+	c.total = total
+
+	body := io.TeeReader(detectTruncation(resp, c.received.start), withRateLimit(ctx, in, withMetricsBytes(in, createProgressWriter(in.ProgressHandler))))
+
+	if plan.destAt != nil {
+		// The range is written in place, so it can't spill into its neighbors.
+		if start != c.requested.start || end > c.requested.end {
+			return &ChunkAssemblyError{
+				Offset: c.requested.start,
+				Reason: fmt.Sprintf("Content-Range %q doesn't match the requested range", resp.Header.Get("Content-Range")),
+			}
+		}
+
+		c.written, err = copyWithContext(ctx, &offsetWriter{w: plan.destAt, off: start}, body)
+
+		return checkDiskFull(err, writerPath(in.Dest))
+	}
+
+	c.written, err = copyWithContext(ctx, c.staging, body)
+
+	return checkDiskFull(err, writerPath(c.staging))
+}
+
+// offsetWriter writes sequentially into an io.WriterAt, starting at an offset.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	return n, err
+}
+
+// verifyChunks checks that the received chunks cover the file exactly, with no
+// gaps or overlaps, and that each chunk contains the amount of data its
+// Content-Range reported. The chunks are sorted by the range they received, so
+// they can be assembled in order.
+func verifyChunks(chunks []*chunk, size int64) error {
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].received.start < chunks[j].received.start
+	})
+
+	var offset int64
+	for _, c := range chunks {
+		r := c.received
+
+		switch {
+		case c.total >= 0 && c.total != size:
+			return &ChunkAssemblyError{Offset: r.start, Reason: fmt.Sprintf("chunk reports a total size of %d, expected %d", c.total, size)}
+		case r.start > offset:
+			return &ChunkAssemblyError{Offset: offset, Reason: fmt.Sprintf("missing bytes %d-%d", offset, r.start-1)}
+		case r.start < offset:
+			return &ChunkAssemblyError{Offset: r.start, Reason: fmt.Sprintf("bytes %d-%d overlap the previous chunk", r.start, offset-1)}
+		case c.written != r.end-r.start+1:
+			return &ChunkAssemblyError{Offset: r.start, Reason: fmt.Sprintf("received %d bytes for range %d-%d", c.written, r.start, r.end)}
+		}
+
+		offset = r.end + 1
+	}
+
+	if offset != size {
+		return &ChunkAssemblyError{Offset: offset, Reason: fmt.Sprintf("assembled %d bytes, expected %d", offset, size)}
+	}
+
+	return nil
+}
@@ -0,0 +1,477 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadConcurrency(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 10000)
+	data = append(data, `abc`...)
+
+	t.Run(`given a server that supports ranges`, func(t *testing.T) {
+		var ranged int32
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(`Range`) != `` {
+				atomic.AddInt32(&ranged, 1)
+			}
+			http.ServeContent(w, r, `data.bin`, time.Time{}, bytes.NewReader(data))
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		var total int64
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:      source,
+			Dest:        &buf,
+			Concurrency: 4,
+			ProgressHandler: cargo.ProgressHandlerFunc(func(expected, received int64) {
+				atomic.StoreInt64(&total, received)
+			}),
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+		assert.Equal(t, int64(len(data)), out.FileSize)
+		assert.Equal(t, 4, out.ChunkCount)
+		assert.Equal(t, int64(len(data)), atomic.LoadInt64(&total))
+		assert.Equal(t, int32(4), atomic.LoadInt32(&ranged))
+	})
+
+	t.Run(`given a chunk size`, func(t *testing.T) {
+		var ranged, active, maxActive int32
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(`Range`) != `` {
+				atomic.AddInt32(&ranged, 1)
+
+				n := atomic.AddInt32(&active, 1)
+				defer atomic.AddInt32(&active, -1)
+				for {
+					max := atomic.LoadInt32(&maxActive)
+					if n <= max || atomic.CompareAndSwapInt32(&maxActive, max, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			http.ServeContent(w, r, `data.bin`, time.Time{}, bytes.NewReader(data))
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:      source,
+			Dest:        &buf,
+			ChunkSize:   30000,
+			Concurrency: 2,
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+		assert.Equal(t, 4, out.ChunkCount)
+		assert.Equal(t, int32(4), atomic.LoadInt32(&ranged))
+		assert.LessOrEqual(t, atomic.LoadInt32(&maxActive), int32(2))
+	})
+
+	t.Run(`given a memory budget`, func(t *testing.T) {
+		var active, maxActive int32
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(`Range`) != `` {
+				n := atomic.AddInt32(&active, 1)
+				defer atomic.AddInt32(&active, -1)
+				for {
+					max := atomic.LoadInt32(&maxActive)
+					if n <= max || atomic.CompareAndSwapInt32(&maxActive, max, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			http.ServeContent(w, r, `data.bin`, time.Time{}, bytes.NewReader(data))
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:       source,
+			Dest:         &buf,
+			Concurrency:  4,
+			MemoryBudget: cargo.NewMemoryBudget(64 * 1024),
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+		assert.Equal(t, 4, out.ChunkCount)
+		assert.LessOrEqual(t, atomic.LoadInt32(&maxActive), int32(2))
+	})
+
+	t.Run(`given a progress handler`, func(t *testing.T) {
+		var expected int64 = -2
+		var mu sync.Mutex
+		var expectedBeforeGet []int64
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				mu.Lock()
+				expectedBeforeGet = append(expectedBeforeGet, atomic.LoadInt64(&expected))
+				mu.Unlock()
+			}
+			http.ServeContent(w, r, `data.bin`, time.Time{}, bytes.NewReader(data))
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:      source,
+			Dest:        &bytes.Buffer{},
+			Concurrency: 2,
+			ProgressHandler: cargo.ProgressHandlerFunc(func(e, _ int64) {
+				atomic.StoreInt64(&expected, e)
+			}),
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []int64{int64(len(data)), int64(len(data))}, expectedBeforeGet)
+	})
+
+	t.Run(`given a progress handler and an expected size`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, `data.bin`, time.Time{}, bytes.NewReader(data))
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		var mu sync.Mutex
+		var expected []int64
+
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:       source,
+			Dest:         &bytes.Buffer{},
+			Concurrency:  2,
+			ExpectedSize: 1 << 20,
+			ProgressHandler: cargo.ProgressHandlerFunc(func(e, _ int64) {
+				mu.Lock()
+				defer mu.Unlock()
+				if len(expected) == 0 || expected[len(expected)-1] != e {
+					expected = append(expected, e)
+				}
+			}),
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, out.ChunkCount)
+		assert.Equal(t, []int64{1 << 20}, expected)
+	})
+
+	t.Run(`given a server without range support`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:      source,
+			Dest:        &buf,
+			Concurrency: 4,
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+	})
+
+	t.Run(`given a response validator`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(`Content-Disposition`, `attachment; filename="report.bin"`)
+			http.ServeContent(w, r, `data.bin`, time.Time{}, bytes.NewReader(data))
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		t.Run(`that rejects the response`, func(t *testing.T) {
+			var calls int32
+			rejected := errors.New(`rejected`)
+
+			var buf bytes.Buffer
+			_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+				Source:      source,
+				Dest:        &buf,
+				Concurrency: 4,
+				ValidateResponse: func(*http.Response) error {
+					atomic.AddInt32(&calls, 1)
+					return rejected
+				},
+			})
+
+			assert.ErrorIs(t, err, rejected)
+			assert.NotZero(t, atomic.LoadInt32(&calls))
+			assert.Equal(t, 0, buf.Len())
+		})
+
+		t.Run(`that accepts the response`, func(t *testing.T) {
+			dir := t.TempDir()
+
+			out, err := cargo.DownloadToFile(context.Background(), dir, cargo.DownloadInput{
+				Source:      source,
+				Concurrency: 4,
+			})
+
+			require.NoError(t, err)
+
+			assert.Equal(t, 4, out.ChunkCount)
+
+			written, err := os.ReadFile(filepath.Join(dir, `report.bin`))
+			require.NoError(t, err)
+			assert.Equal(t, data, written)
+		})
+	})
+
+	t.Run(`given a chunk with the wrong Content-Range`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(`Accept-Ranges`, `bytes`)
+			if r.Method == http.MethodHead {
+				w.Header().Set(`Content-Length`, fmt.Sprint(len(data)))
+				return
+			}
+
+			var start, end int
+			fmt.Sscanf(strings.TrimPrefix(r.Header.Get(`Range`), `bytes=`), `%d-%d`, &start, &end)
+
+			// The second chunk reports an offset past the one requested.
+			reported := start
+			if start == len(data)/4 {
+				reported += 10
+			}
+
+			w.Header().Set(`Content-Range`, fmt.Sprintf(`bytes %d-%d/%d`, reported, reported+end-start, len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[start : end+1])
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:      source,
+			Dest:        &buf,
+			Concurrency: 4,
+		})
+
+		var assemblyErr *cargo.ChunkAssemblyError
+		require.True(t, errors.As(err, &assemblyErr))
+
+		assert.Equal(t, int64(len(data)/4), assemblyErr.Offset)
+		assert.Contains(t, assemblyErr.Error(), `missing bytes`)
+		assert.Equal(t, 0, buf.Len())
+	})
+}
+
+// writerAtRecorder is a destination that implements io.WriterAt, and records
+// how it was written to.
+type writerAtRecorder struct {
+	mu      sync.Mutex
+	data    []byte
+	writes  int
+	writeAt int
+}
+
+func (w *writerAtRecorder) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.writes++
+	w.data = append(w.data, b...)
+	return len(b), nil
+}
+
+func (w *writerAtRecorder) WriteAt(b []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.writeAt++
+	if end := int(off) + len(b); end > len(w.data) {
+		w.data = append(w.data, make([]byte, end-len(w.data))...)
+	}
+	copy(w.data[off:], b)
+	return len(b), nil
+}
+
+func TestDownloadWriteChunksToDest(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 10000)
+	data = append(data, `abc`...)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, `data.bin`, time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given a WriterAt destination`, func(t *testing.T) {
+		dest := &writerAtRecorder{}
+
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:            source,
+			Dest:              dest,
+			Concurrency:       4,
+			WriteChunksToDest: true,
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, dest.data)
+		assert.Equal(t, 0, dest.writes)
+		assert.Greater(t, dest.writeAt, 0)
+		assert.Equal(t, int64(len(data)), out.FileSize)
+		assert.Equal(t, 4, out.ChunkCount)
+	})
+
+	t.Run(`given a checksum`, func(t *testing.T) {
+		dest := &writerAtRecorder{}
+		sum := sha256.Sum256(data)
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:            source,
+			Dest:              dest,
+			Concurrency:       4,
+			WriteChunksToDest: true,
+			ExpectedChecksum:  sum[:],
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, dest.data)
+		assert.Equal(t, 0, dest.writeAt)
+	})
+
+	t.Run(`given a file`, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), `data.bin`)
+
+		f, err := os.Create(path)
+		require.NoError(t, err)
+		defer f.Close()
+
+		_, err = cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:            source,
+			Dest:              f,
+			ChunkSize:         30000,
+			WriteChunksToDest: true,
+		})
+
+		require.NoError(t, err)
+
+		written, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, data, written)
+	})
+
+	t.Run(`given a file longer than the download`, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), `data.bin`)
+		require.NoError(t, os.WriteFile(path, bytes.Repeat([]byte(`x`), len(data)+100), 0644))
+
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		require.NoError(t, err)
+		defer f.Close()
+
+		_, err = cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:            source,
+			Dest:              f,
+			ChunkSize:         30000,
+			WriteChunksToDest: true,
+		})
+
+		require.NoError(t, err)
+
+		offset, err := f.Seek(0, io.SeekCurrent)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), offset)
+
+		written, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, data, written)
+	})
+
+	t.Run(`given a file past its start`, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), `data.bin`)
+
+		f, err := os.Create(path)
+		require.NoError(t, err)
+		defer f.Close()
+
+		_, err = f.Write([]byte(`header`))
+		require.NoError(t, err)
+
+		_, err = cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:            source,
+			Dest:              f,
+			ChunkSize:         30000,
+			WriteChunksToDest: true,
+		})
+
+		require.NoError(t, err)
+
+		written, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, append([]byte(`header`), data...), written)
+	})
+
+	t.Run(`given a file opened for appending`, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), `data.bin`)
+
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		require.NoError(t, err)
+		defer f.Close()
+
+		_, err = cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:            source,
+			Dest:              f,
+			ChunkSize:         30000,
+			WriteChunksToDest: true,
+		})
+
+		require.NoError(t, err)
+
+		written, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, data, written)
+	})
+}
@@ -0,0 +1,221 @@
+package cargo
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// buildsClient reports if the input sets any of the options that require Cargo
+// to build its own client, rather than using the default client.
+func (in DownloadInput) buildsClient() bool {
+	return in.DialTimeout > 0 || in.DialContext != nil || in.TLSConfig != nil || in.ForceHTTP2 || in.SOCKS5 != nil ||
+		in.MaxIdleConns > 0 || in.MaxIdleConnsPerHost > 0 || in.IdleConnTimeout > 0
+}
+
+// The idle connection limits of the transports built by Cargo, when the input
+// doesn't set its own.
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 16
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// The maximum number of transports kept by builtHTTPClient. Once it is reached
+// a transport is dropped, and its idle connections closed, to make room for
+// another, so inputs that use a new TLSConfig for every download can't grow
+// the cache without bound.
+const maxCachedTransports = 32
+
+// transportKey is the transport options of an input, which identify the
+// transport its downloads share.
+type transportKey struct {
+	dialTimeout         time.Duration
+	tlsConfig           *tls.Config
+	forceHTTP2          bool
+	socks5              string
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+}
+
+var (
+	builtClientsMu sync.Mutex
+	builtClients   = map[transportKey]*http.Client{}
+)
+
+// builtHTTPClient returns the client for the input's transport options, shared
+// with every other download using the same options, so they reuse each other's
+// idle connections. An input with a DialContext can't be compared with others,
+// so it gets a client of its own, and owned is true.
+func builtHTTPClient(in DownloadInput) (client *http.Client, owned bool) {
+	if in.DialContext != nil {
+		return newHTTPClient(in), true
+	}
+
+	key := transportKey{
+		dialTimeout:         in.DialTimeout,
+		tlsConfig:           in.TLSConfig,
+		forceHTTP2:          in.ForceHTTP2,
+		maxIdleConns:        in.MaxIdleConns,
+		maxIdleConnsPerHost: in.MaxIdleConnsPerHost,
+		idleConnTimeout:     in.IdleConnTimeout,
+	}
+	if in.SOCKS5 != nil {
+		key.socks5 = in.SOCKS5.String()
+	}
+
+	builtClientsMu.Lock()
+	defer builtClientsMu.Unlock()
+
+	if client, ok := builtClients[key]; ok {
+		return client, false
+	}
+
+	if len(builtClients) >= maxCachedTransports {
+		for k, c := range builtClients {
+			c.CloseIdleConnections()
+			delete(builtClients, k)
+			break
+		}
+	}
+
+	client = newHTTPClient(in)
+	builtClients[key] = client
+
+	return client, false
+}
+
+// releaseClient closes the idle connections of a client built for the input
+// alone, once its download has completed.
+func (in DownloadInput) releaseClient() {
+	if in.ownsClient {
+		in.HTTPClient.CloseIdleConnections()
+	}
+}
+
+// newHTTPClient builds a client with a transport configured from the input's
+// options. The transport starts from a clone of http.DefaultTransport, so the
+// standard proxy and connection settings still apply.
+func newHTTPClient(in DownloadInput) *http.Client {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if ok {
+		transport = transport.Clone()
+	} else {
+		transport = &http.Transport{Proxy: http.ProxyFromEnvironment}
+	}
+
+	transport.MaxIdleConns = defaultMaxIdleConns
+	if in.MaxIdleConns > 0 {
+		transport.MaxIdleConns = in.MaxIdleConns
+	}
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	if in.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = in.MaxIdleConnsPerHost
+	}
+	transport.IdleConnTimeout = defaultIdleConnTimeout
+	if in.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = in.IdleConnTimeout
+	}
+
+	if in.DialContext != nil || in.DialTimeout > 0 {
+		transport.DialContext = newDialContext(in)
+	}
+	if in.SOCKS5 != nil {
+		transport.Proxy = nil
+		transport.DialContext = newSOCKS5DialContext(in)
+	}
+	if in.TLSConfig != nil {
+		transport.TLSClientConfig = in.TLSConfig.Clone()
+	}
+
+	if in.ForceHTTP2 {
+		transport.ForceAttemptHTTP2 = true
+		return &http.Client{Transport: &http2OnlyTransport{transport}}
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// ErrHTTP2Unavailable is the error returned when DownloadInput.ForceHTTP2 is
+// set, and the server doesn't respond using HTTP/2.
+var ErrHTTP2Unavailable = errors.New(`http/2 unavailable`)
+
+// http2OnlyTransport rejects any response that wasn't received over HTTP/2.
+type http2OnlyTransport struct {
+	http.RoundTripper
+}
+
+// CloseIdleConnections closes the idle connections of the wrapped transport.
+func (t *http2OnlyTransport) CloseIdleConnections() {
+	if c, ok := t.RoundTripper.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+func (t *http2OnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ProtoMajor != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w (server responded with %s)", ErrHTTP2Unavailable, resp.Proto)
+	}
+	return resp, nil
+}
+
+func newDialContext(in DownloadInput) func(context.Context, string, string) (net.Conn, error) {
+	if in.DialContext == nil {
+		dialer := &net.Dialer{
+			Timeout:   in.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}
+		return dialer.DialContext
+	}
+
+	if in.DialTimeout <= 0 {
+		return in.DialContext
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, in.DialTimeout)
+		defer cancel()
+
+		return in.DialContext(ctx, network, addr)
+	}
+}
+
+// newSOCKS5DialContext returns a dial function connecting through the input's
+// SOCKS5 proxy. The connection to the proxy itself is made using the input's
+// other dial options.
+func newSOCKS5DialContext(in DownloadInput) func(context.Context, string, string) (net.Conn, error) {
+	var auth *proxy.Auth
+	if in.SOCKS5.User != nil {
+		password, _ := in.SOCKS5.User.Password()
+		auth = &proxy.Auth{User: in.SOCKS5.User.Username(), Password: password}
+	}
+
+	// proxy.SOCKS5 only fails for networks other than tcp.
+	dialer, _ := proxy.SOCKS5("tcp", in.SOCKS5.Host, auth, forwardDialer(newDialContext(in)))
+
+	return dialer.(proxy.ContextDialer).DialContext
+}
+
+// forwardDialer adapts a dial function to the proxy.Dialer interface.
+type forwardDialer func(context.Context, string, string) (net.Conn, error)
+
+func (d forwardDialer) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+func (d forwardDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
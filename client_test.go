@@ -0,0 +1,390 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadDialContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given a dial function`, func(t *testing.T) {
+		var dialed []string
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   &buf,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dialed = append(dialed, addr)
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{source.Host}, dialed)
+		assert.Equal(t, `hello`, buf.String())
+	})
+
+	t.Run(`given a dial timeout`, func(t *testing.T) {
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:      source,
+			Dest:        &bytes.Buffer{},
+			DialTimeout: 10 * time.Millisecond,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		})
+
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+}
+
+func TestDownloadReusesBuiltTransport(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		opened int
+		closed int
+	)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`hello`))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch state {
+		case http.StateNew:
+			opened++
+		case http.StateClosed:
+			closed++
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	counts := func() (int, int) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return opened, closed
+	}
+
+	t.Run(`given downloads with the same transport options`, func(t *testing.T) {
+		before, _ := counts()
+
+		for i := 0; i < 5; i++ {
+			_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+				Source:              source,
+				Dest:                &bytes.Buffer{},
+				MaxIdleConnsPerHost: 10,
+			})
+			require.NoError(t, err)
+		}
+
+		after, _ := counts()
+		assert.Equal(t, 1, after-before)
+	})
+
+	t.Run(`given a dial function`, func(t *testing.T) {
+		before, _ := counts()
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   &bytes.Buffer{},
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		})
+		require.NoError(t, err)
+
+		after, _ := counts()
+		assert.Equal(t, 1, after-before)
+
+		// The download's own transport doesn't keep its connection once it
+		// completes.
+		assert.Eventually(t, func() bool {
+			opened, closed := counts()
+			return opened-closed <= 1
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestDownloadTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given a config trusting the server`, func(t *testing.T) {
+		pool := x509.NewCertPool()
+		pool.AddCert(server.Certificate())
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:    source,
+			Dest:      &buf,
+			TLSConfig: &tls.Config{RootCAs: pool},
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, `hello`, buf.String())
+	})
+
+	t.Run(`given no config`, func(t *testing.T) {
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   &bytes.Buffer{},
+		})
+
+		assert.Error(t, err)
+	})
+}
+
+func TestDownloadForceHTTP2(t *testing.T) {
+	newServer := func(h2 bool) *httptest.Server {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}))
+		server.EnableHTTP2 = h2
+		server.StartTLS()
+		return server
+	}
+
+	download := func(server *httptest.Server) (string, error) {
+		source, _ := url.Parse(server.URL)
+
+		pool := x509.NewCertPool()
+		pool.AddCert(server.Certificate())
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:     source,
+			Dest:       &buf,
+			TLSConfig:  &tls.Config{RootCAs: pool},
+			ForceHTTP2: true,
+		})
+
+		return buf.String(), err
+	}
+
+	t.Run(`given an h2 server`, func(t *testing.T) {
+		server := newServer(true)
+		defer server.Close()
+
+		proto, err := download(server)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, `HTTP/2.0`, proto)
+	})
+
+	t.Run(`given an http/1.1 server`, func(t *testing.T) {
+		server := newServer(false)
+		defer server.Close()
+
+		_, err := download(server)
+
+		assert.ErrorIs(t, err, cargo.ErrHTTP2Unavailable)
+	})
+}
+
+func TestDownloadSOCKS5(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	proxyAddr, targets := serveSOCKS5(t, `user`, `secret`)
+
+	t.Run(`given valid credentials`, func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   &buf,
+			SOCKS5: &url.URL{Scheme: `socks5`, Host: proxyAddr, User: url.UserPassword(`user`, `secret`)},
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, `hello`, buf.String())
+		assert.Equal(t, source.Host, <-targets)
+	})
+
+	t.Run(`given invalid credentials`, func(t *testing.T) {
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   &bytes.Buffer{},
+			SOCKS5: &url.URL{Scheme: `socks5`, Host: proxyAddr, User: url.UserPassword(`user`, `wrong`)},
+		})
+
+		assert.Error(t, err)
+	})
+}
+
+// serveSOCKS5 starts a minimal SOCKS5 proxy requiring username/password
+// authentication, and sends the target of each CONNECT request to the
+// returned channel.
+func serveSOCKS5(t *testing.T, user, password string) (string, <-chan string) {
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	targets := make(chan string, 10)
+
+	handle := func(conn net.Conn) error {
+		defer conn.Close()
+
+		// Greeting, selecting username/password authentication.
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+			return err
+		}
+		conn.Write([]byte{5, 2})
+
+		// Username/password authentication.
+		readField := func() (string, error) {
+			size := make([]byte, 1)
+			if _, err := io.ReadFull(conn, size); err != nil {
+				return "", err
+			}
+			field := make([]byte, size[0])
+			_, err := io.ReadFull(conn, field)
+			return string(field), err
+		}
+		if _, err := io.ReadFull(conn, make([]byte, 1)); err != nil {
+			return err
+		}
+		u, err := readField()
+		if err != nil {
+			return err
+		}
+		p, err := readField()
+		if err != nil {
+			return err
+		}
+		if u != user || p != password {
+			conn.Write([]byte{1, 1})
+			return nil
+		}
+		conn.Write([]byte{1, 0})
+
+		// CONNECT request.
+		request := make([]byte, 4)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return err
+		}
+		var host string
+		switch request[3] {
+		case 1:
+			ip := make([]byte, 4)
+			if _, err := io.ReadFull(conn, ip); err != nil {
+				return err
+			}
+			host = net.IP(ip).String()
+		case 3:
+			if host, err = readField(); err != nil {
+				return err
+			}
+		default:
+			return errors.New(`unsupported address type`)
+		}
+		port := make([]byte, 2)
+		if _, err := io.ReadFull(conn, port); err != nil {
+			return err
+		}
+		target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+		targets <- target
+
+		upstream, err := net.Dial(`tcp`, target)
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return err
+		}
+		defer upstream.Close()
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+		go io.Copy(upstream, conn)
+		_, err = io.Copy(conn, upstream)
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+
+	return l.Addr().String(), targets
+}
+
+func TestDownloadTraceHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	var traces []cargo.DownloadTrace
+	for i := 0; i < 2; i++ {
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:     source,
+			Dest:       &bytes.Buffer{},
+			HTTPClient: server.Client(),
+			TraceHandler: func(trace cargo.DownloadTrace) {
+				traces = append(traces, trace)
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	require.Len(t, traces, 2)
+
+	assert.Equal(t, http.MethodGet, traces[0].Method)
+	assert.Equal(t, source.String(), traces[0].URL.String())
+	assert.False(t, traces[0].Reused)
+	assert.Greater(t, int64(traces[0].Connect), int64(0))
+	assert.Greater(t, int64(traces[0].TimeToFirstByte), int64(0))
+
+	assert.True(t, traces[1].Reused)
+	assert.Equal(t, time.Duration(0), traces[1].Connect)
+}
@@ -0,0 +1,112 @@
+package cargo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// clock is the source of time for the time based features: the read, copy,
+// and attempt timeouts, MinThroughput, retry backoff, and rate limiting. The
+// wall clock is used by default, and tests replace it with a clock they
+// control, so those features can be tested without waiting on real time.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) clockTimer
+}
+
+// clockTimer is a timer created by a clock.
+type clockTimer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// realClock is the wall clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) clockTimer    { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+// clockOrDefault returns c, or the wall clock if c is nil.
+func clockOrDefault(c clock) clock {
+	if c == nil {
+		return realClock{}
+	}
+	return c
+}
+
+// withTimeout is context.WithTimeout, with the timeout measured by the clock.
+func withTimeout(ctx context.Context, c clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := clockOrDefault(c).(realClock); ok {
+		return context.WithTimeout(ctx, d)
+	}
+
+	timeoutCtx := &clockContext{
+		Context:  ctx,
+		deadline: c.Now().Add(d),
+		done:     make(chan struct{}),
+	}
+
+	timer := c.NewTimer(d)
+	go func() {
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			timeoutCtx.finish(ctx.Err())
+		case <-timer.C():
+			timeoutCtx.finish(context.DeadlineExceeded)
+		case <-timeoutCtx.done:
+		}
+	}()
+
+	return timeoutCtx, func() { timeoutCtx.finish(context.Canceled) }
+}
+
+// clockContext is a context with a deadline measured by a clock other than the
+// wall clock, which the standard library's contexts can't use.
+type clockContext struct {
+	context.Context
+	deadline time.Time
+	done     chan struct{}
+
+	once sync.Once
+	mu   sync.Mutex
+	err  error
+}
+
+func (c *clockContext) finish(err error) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		close(c.done)
+	})
+}
+
+// Deadline returns the earlier of the clock's deadline and the parent's.
+func (c *clockContext) Deadline() (time.Time, bool) {
+	if parent, ok := c.Context.Deadline(); ok && parent.Before(c.deadline) {
+		return parent, true
+	}
+	return c.deadline, true
+}
+
+func (c *clockContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *clockContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
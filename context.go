@@ -0,0 +1,38 @@
+package cargo
+
+import (
+	"context"
+	"net/http"
+)
+
+type contextKey int
+
+const (
+	authTokenContextKey contextKey = iota
+)
+
+// WithAuthToken returns a copy of the context carrying the given token. The
+// default request builder sends the token as a bearer token in the
+// Authorization header. This keeps per-call credentials out of a shared
+// DownloadInput.
+//
+// A custom DownloadInput.CreateRequest can use AuthTokenFromContext to apply
+// the token itself.
+func WithAuthToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, authTokenContextKey, token)
+}
+
+// AuthTokenFromContext returns the token set with WithAuthToken. The boolean
+// is false if the context doesn't carry a token.
+func AuthTokenFromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(authTokenContextKey).(string)
+	return token, ok
+}
+
+// applyContextValues sets the request headers derived from the well-known
+// values carried by the request's context.
+func applyContextValues(req *http.Request) {
+	if token, ok := AuthTokenFromContext(req.Context()); ok && token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
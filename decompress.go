@@ -0,0 +1,152 @@
+package cargo
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Decoder creates a reader decompressing a body sent with a Content-Encoding.
+type Decoder func(io.Reader) (io.Reader, error)
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]Decoder{
+		"gzip":    newGzipDecoder,
+		"x-gzip":  newGzipDecoder,
+		"deflate": newDeflateDecoder,
+	}
+)
+
+func newGzipDecoder(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+// newDeflateDecoder reads the zlib format, which HTTP calls deflate.
+func newDeflateDecoder(r io.Reader) (io.Reader, error) {
+	return zlib.NewReader(r)
+}
+
+// RegisterDecoder registers the decoder used by DownloadInput.Decompress for a
+// Content-Encoding, such as "zstd" or "br", so encodings can be supported
+// without Cargo depending on their implementations. The encoding is matched
+// case-insensitively. The gzip and deflate encodings are built in, and
+// registering an encoding again replaces its decoder. It is safe for
+// concurrent use, and is usually called from an init function.
+func RegisterDecoder(encoding string, factory Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+
+	decoders[strings.ToLower(strings.TrimSpace(encoding))] = factory
+}
+
+// shouldDecompress returns the decoder the response body must be decompressed
+// with for the input's Decompress option, or nil if it is left as is. The
+// transport already decompresses the body when it added the Accept-Encoding
+// header itself, which it reports by setting resp.Uncompressed, so the body is
+// only decompressed when it's still encoded.
+func shouldDecompress(in DownloadInput, resp *http.Response) Decoder {
+	if !in.Decompress {
+		return nil
+	}
+	return responseDecoder(resp)
+}
+
+// responseDecoder returns the registered decoder for the response's
+// Content-Encoding, or nil if the body isn't encoded with a single registered
+// encoding.
+func responseDecoder(resp *http.Response) Decoder {
+	if resp == nil || resp.Uncompressed {
+		return nil
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return nil
+	}
+
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+
+	return decoders[encoding]
+}
+
+// decodingReader decompresses an encoded stream. The decoder is created by the
+// first call to Read rather than up front, so reading a header, like gzip's, is
+// covered by the read's timeouts.
+type decodingReader struct {
+	r       io.Reader
+	decoder Decoder
+	d       io.Reader
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	if d.d == nil {
+		dr, err := d.decoder(d.r)
+		if err != nil {
+			return 0, err
+		}
+		d.d = dr
+	}
+	return d.d.Read(p)
+}
+
+// stagedReader returns the reader the staged data is copied to Dest from. A
+// resumed download stages a compressed body as it was received, as its offsets
+// are in the compressed bytes, so it is decompressed with the decoder once it's
+// complete. A nil decoder leaves the data as is.
+func stagedReader(s io.ReadSeeker, decoder Decoder) io.ReadSeeker {
+	if decoder == nil {
+		return s
+	}
+	return &decompressedStaging{s: s, decoder: decoder}
+}
+
+// decompressedStaging reads compressed staged data as decompressed data. It
+// can only be rewound to the start, which restarts the decompression.
+type decompressedStaging struct {
+	s       io.ReadSeeker
+	decoder Decoder
+	r       io.Reader
+}
+
+func (d *decompressedStaging) Read(p []byte) (int, error) {
+	if d.r == nil {
+		d.r = &decodingReader{r: d.s, decoder: d.decoder}
+	}
+	return d.r.Read(p)
+}
+
+func (d *decompressedStaging) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New(`cargo: decompressed staging can only be rewound`)
+	}
+	if _, err := d.s.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	d.r = nil
+	return 0, nil
+}
+
+// checkStagedDecompressedSize checks the size of a resumed download's staged
+// data once it is decompressed, which takes a pass over the data, so it is
+// only read when the input sets ExpectDecompressedSize.
+func checkStagedDecompressedSize(ctx context.Context, in DownloadInput, staged io.ReadSeeker) error {
+	if in.ExpectDecompressedSize <= 0 {
+		return nil
+	}
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	size, err := copyWithContext(ctx, io.Discard, staged)
+	if err != nil {
+		return err
+	}
+	return checkDecompressedSize(in, size)
+}
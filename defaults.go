@@ -0,0 +1,87 @@
+package cargo
+
+import (
+	"net/http"
+	"sync"
+)
+
+const (
+	defaultUserAgentString = "Go-Cargo (github.com/maddiesch/go-cargo)"
+	defaultErrorBodySize   = 4 * 1024
+)
+
+var (
+	defaultsMu            sync.RWMutex
+	defaultClient         *http.Client
+	defaultUserAgent      = defaultUserAgentString
+	defaultErrorBodyLimit = int64(defaultErrorBodySize)
+)
+
+// SetDefaultClient sets the *http.Client used by downloads that don't specify
+// DownloadInput.HTTPClient. Passing nil restores http.DefaultClient.
+//
+// It is safe to call concurrently with running downloads. A download reads the
+// default once when it starts, so the new client is only used by downloads
+// started after the call returns. The client itself is shared, so it must be
+// safe for concurrent use (as every *http.Client is).
+func SetDefaultClient(c *http.Client) {
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+
+	defaultClient = c
+}
+
+// SetDefaultUserAgent sets the User-Agent header applied by the default
+// request builder. Passing an empty string restores Cargo's own User-Agent.
+//
+// Like SetDefaultClient it is safe for concurrent use and only affects
+// downloads started after the call returns.
+func SetDefaultUserAgent(ua string) {
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+
+	if ua == "" {
+		ua = defaultUserAgentString
+	}
+	defaultUserAgent = ua
+}
+
+func defaultHTTPClient() *http.Client {
+	defaultsMu.RLock()
+	defer defaultsMu.RUnlock()
+
+	if defaultClient == nil {
+		return http.DefaultClient
+	}
+	return defaultClient
+}
+
+func defaultUserAgentValue() string {
+	defaultsMu.RLock()
+	defer defaultsMu.RUnlock()
+
+	return defaultUserAgent
+}
+
+// SetDefaultErrorBodyLimit sets the maximum number of bytes of a rejected
+// response's body that are read into HTTPResponseError.Body. A negative value
+// disables reading the body, and zero restores the default of 4KB.
+//
+// It is safe for concurrent use, and affects any response rejected after the
+// call returns.
+func SetDefaultErrorBodyLimit(n int64) {
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+
+	if n == 0 {
+		n = defaultErrorBodySize
+	}
+	defaultErrorBodyLimit = n
+}
+
+func defaultErrorBodyLimitValue() int64 {
+	defaultsMu.RLock()
+	defer defaultsMu.RUnlock()
+
+	return defaultErrorBodyLimit
+}
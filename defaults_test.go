@@ -0,0 +1,86 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingTransport struct {
+	count int
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.count++
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestSetDefaultClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.UserAgent()))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given a default client and user agent`, func(t *testing.T) {
+		transport := &countingTransport{}
+
+		cargo.SetDefaultClient(&http.Client{Transport: transport})
+		cargo.SetDefaultUserAgent(`test-agent`)
+		defer cargo.SetDefaultClient(nil)
+		defer cargo.SetDefaultUserAgent(``)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{Source: source, Dest: &buf})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, transport.count)
+		assert.Equal(t, `test-agent`, buf.String())
+	})
+
+	t.Run(`given an explicit client`, func(t *testing.T) {
+		defaultTransport := &countingTransport{}
+		inputTransport := &countingTransport{}
+
+		cargo.SetDefaultClient(&http.Client{Transport: defaultTransport})
+		defer cargo.SetDefaultClient(nil)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:     source,
+			Dest:       &buf,
+			HTTPClient: &http.Client{Transport: inputTransport},
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, defaultTransport.count)
+		assert.Equal(t, 1, inputTransport.count)
+		assert.Equal(t, `Go-Cargo (github.com/maddiesch/go-cargo)`, buf.String())
+	})
+}
+
+func TestWithAuthToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get(`Authorization`)))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	var buf bytes.Buffer
+	_, err := cargo.Download(cargo.WithAuthToken(context.Background(), `secret`), cargo.DownloadInput{Source: source, Dest: &buf})
+
+	require.NoError(t, err)
+
+	assert.Equal(t, `Bearer secret`, buf.String())
+}
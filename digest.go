@@ -0,0 +1,89 @@
+package cargo
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// digestAlgorithms maps the supported algorithms of the Digest trailer, in
+// lowercase, to the name used in a ChecksumError and the hash's constructor.
+var digestAlgorithms = map[string]struct {
+	name string
+	new  func() hash.Hash
+}{
+	"md5":     {"md5", md5.New},
+	"sha":     {"sha1", sha1.New},
+	"sha-256": {"sha256", sha256.New},
+	"sha-512": {"sha512", sha512.New},
+}
+
+// digestTrailerVerifier computes the digests of the response body, to be
+// compared against the response's Digest trailer once the body has been read.
+type digestTrailerVerifier struct {
+	resp   *http.Response
+	hashes map[string]hash.Hash
+}
+
+// newDigestTrailerVerifier returns a verifier for the response, or nil if the
+// input doesn't verify the Digest trailer, the response doesn't announce one,
+// or the response doesn't start at the beginning of the file.
+func newDigestTrailerVerifier(in DownloadInput, resp *http.Response, offset int64) *digestTrailerVerifier {
+	if !in.VerifyDigestTrailer || offset > 0 {
+		return nil
+	}
+	if _, ok := resp.Trailer["Digest"]; !ok {
+		return nil
+	}
+
+	v := &digestTrailerVerifier{resp: resp, hashes: make(map[string]hash.Hash, len(digestAlgorithms))}
+	for alg, d := range digestAlgorithms {
+		v.hashes[alg] = d.new()
+	}
+	return v
+}
+
+func (v *digestTrailerVerifier) wrap(r io.Reader) io.Reader {
+	writers := make([]io.Writer, 0, len(v.hashes))
+	for _, h := range v.hashes {
+		writers = append(writers, h)
+	}
+	return io.TeeReader(r, io.MultiWriter(writers...))
+}
+
+// verify compares each supported digest in the trailer, in the form
+// "SHA-256=<base64>, MD5=<base64>", with the digest of the body.
+func (v *digestTrailerVerifier) verify() error {
+	for _, entry := range strings.Split(v.resp.Trailer.Get("Digest"), ",") {
+		entry = strings.TrimSpace(entry)
+		idx := strings.IndexByte(entry, '=')
+		if idx == -1 {
+			continue
+		}
+		alg, value := strings.ToLower(entry[:idx]), entry[idx+1:]
+
+		h, ok := v.hashes[alg]
+		if !ok {
+			continue
+		}
+
+		expected, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("invalid Digest trailer: %w", err)
+		}
+
+		actual := h.Sum(nil)
+		if !bytes.Equal(actual, expected) {
+			return &ChecksumError{Algorithm: digestAlgorithms[alg].name, Expected: expected, Actual: actual}
+		}
+	}
+	return nil
+}
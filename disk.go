@@ -0,0 +1,47 @@
+package cargo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DiskFullError is the error returned when writing the download fails because
+// the filesystem is full.
+type DiskFullError struct {
+	Path string // The file being written, if known
+	Err  error  // The underlying write error
+}
+
+func (e *DiskFullError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("disk full: %v", e.Err)
+	}
+	return fmt.Sprintf("disk full writing %s: %v", e.Path, e.Err)
+}
+
+func (e *DiskFullError) Unwrap() error {
+	return e.Err
+}
+
+// checkDiskFull returns a *DiskFullError for the path if err was caused by a
+// full filesystem, otherwise err is returned unchanged.
+func checkDiskFull(err error, path string) error {
+	if err == nil || !isDiskFull(err) {
+		return err
+	}
+	var diskErr *DiskFullError
+	if errors.As(err, &diskErr) {
+		return err
+	}
+	return &DiskFullError{Path: path, Err: err}
+}
+
+// writerPath returns the name of the file written by w, or an empty string if
+// w isn't a file.
+func writerPath(w io.Writer) string {
+	if f, ok := w.(interface{ Name() string }); ok {
+		return f.Name()
+	}
+	return ""
+}
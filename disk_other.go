@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package cargo
+
+import (
+	"errors"
+	"syscall"
+)
+
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
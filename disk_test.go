@@ -0,0 +1,58 @@
+package cargo_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadDiskFull(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`Hello World`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given a full destination file`, func(t *testing.T) {
+		dest, err := os.OpenFile(`/dev/full`, os.O_WRONLY, 0)
+		if err != nil {
+			t.Skip(`/dev/full is not available`)
+		}
+		defer dest.Close()
+
+		_, err = cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   dest,
+		})
+
+		var diskErr *cargo.DiskFullError
+		require.True(t, errors.As(err, &diskErr))
+
+		assert.Equal(t, `/dev/full`, diskErr.Path)
+		assert.ErrorIs(t, err, syscall.ENOSPC)
+	})
+
+	t.Run(`given a full destination writer`, func(t *testing.T) {
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest: writerFunc(func(b []byte) (int, error) {
+				return 0, &os.PathError{Op: `write`, Path: `disk`, Err: syscall.ENOSPC}
+			}),
+		})
+
+		var diskErr *cargo.DiskFullError
+		require.True(t, errors.As(err, &diskErr))
+
+		assert.Equal(t, ``, diskErr.Path)
+	})
+}
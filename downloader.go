@@ -0,0 +1,119 @@
+package cargo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"sync"
+)
+
+// ErrDownloaderClosed is the error returned when a download is started on a
+// Downloader that has been shut down.
+var ErrDownloaderClosed = errors.New(`downloader is shut down`)
+
+// Downloader runs downloads with a shared set of options, and keeps track of
+// the downloads in flight so they can be drained by Shutdown, for example when a
+// service is stopping.
+//
+// The zero value is ready to use. A Downloader must not be copied after first
+// use.
+type Downloader struct {
+	// Options applied to the input of every download, before the options passed
+	// to Download.
+	Options []Option
+
+	mu      sync.Mutex
+	closed  bool
+	active  sync.WaitGroup
+	stop    chan struct{}
+	stopped bool
+}
+
+// NewDownloader returns a Downloader that applies the options to every
+// download.
+func NewDownloader(opts ...Option) *Downloader {
+	return &Downloader{Options: opts}
+}
+
+// Download downloads the source to dest, using the Downloader's options and
+// then opts to configure the rest of the DownloadInput. It returns
+// ErrDownloaderClosed once Shutdown has been called.
+func (d *Downloader) Download(ctx context.Context, source *url.URL, dest io.Writer, opts ...Option) (*DownloadOutput, error) {
+	stop, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer d.active.Done()
+
+	in := DownloadInput{Source: source, Dest: dest}
+	for _, opt := range d.Options {
+		opt(&in)
+	}
+	for _, opt := range opts {
+		opt(&in)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return Download(ctx, in)
+}
+
+// begin registers a download, and returns the channel that is closed when the
+// Downloader cancels the downloads still in flight.
+func (d *Downloader) begin() (<-chan struct{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil, ErrDownloaderClosed
+	}
+	if d.stop == nil {
+		d.stop = make(chan struct{})
+	}
+	d.active.Add(1)
+
+	return d.stop, nil
+}
+
+// Shutdown stops the Downloader from starting new downloads, and waits for the
+// downloads in flight to complete. If the context expires first, the remaining
+// downloads are canceled, and Shutdown returns the context's error once they
+// have returned.
+func (d *Downloader) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	d.mu.Lock()
+	if d.stop != nil && !d.stopped {
+		close(d.stop)
+		d.stopped = true
+	}
+	d.mu.Unlock()
+
+	<-done
+
+	return ctx.Err()
+}
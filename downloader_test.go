@@ -0,0 +1,103 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloaderShutdown(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	shared := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case shared <- r.Header.Get("X-Shared"):
+		default:
+		}
+
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("hello"))
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+
+		select {
+		case <-release:
+			w.Write([]byte("world"))
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	start := func(d *cargo.Downloader) (chan error, *bytes.Buffer) {
+		var buf bytes.Buffer
+		errs := make(chan error, 1)
+		go func() {
+			_, err := d.Download(context.Background(), source, &buf)
+			errs <- err
+		}()
+		<-started
+		return errs, &buf
+	}
+
+	t.Run(`given downloads that complete before the deadline`, func(t *testing.T) {
+		d := cargo.NewDownloader(func(in *cargo.DownloadInput) {
+			in.Header = http.Header{"X-Shared": {"yes"}}
+		})
+
+		errs, buf := start(d)
+
+		shutdown := make(chan error, 1)
+		go func() {
+			shutdown <- d.Shutdown(context.Background())
+		}()
+
+		select {
+		case <-shutdown:
+			t.Fatal("shutdown returned before the download completed")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		_, err := d.Download(context.Background(), source, &bytes.Buffer{})
+		assert.ErrorIs(t, err, cargo.ErrDownloaderClosed)
+
+		release <- struct{}{}
+
+		require.NoError(t, <-errs)
+		assert.Equal(t, "helloworld", buf.String())
+		assert.Equal(t, "yes", <-shared)
+		assert.NoError(t, <-shutdown)
+	})
+
+	t.Run(`given downloads still running at the deadline`, func(t *testing.T) {
+		d := &cargo.Downloader{}
+
+		errs, _ := start(d)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		assert.ErrorIs(t, d.Shutdown(ctx), context.DeadlineExceeded)
+		assert.ErrorIs(t, <-errs, context.Canceled)
+	})
+
+	t.Run(`given no downloads`, func(t *testing.T) {
+		d := &cargo.Downloader{}
+
+		assert.NoError(t, d.Shutdown(context.Background()))
+
+		_, err := d.Download(context.Background(), source, &bytes.Buffer{})
+		assert.ErrorIs(t, err, cargo.ErrDownloaderClosed)
+	})
+}
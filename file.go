@@ -0,0 +1,301 @@
+package cargo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrMissingFilename is the error returned by DownloadToFile when it is given a
+// directory, and no filename can be derived from the response or the URL.
+var ErrMissingFilename = errors.New(`unable to determine download filename`)
+
+// DownloadToFile downloads the input's Source to the file at path.
+//
+// The data is written to a temporary file which is renamed to path once the
+// download is complete, so an existing file is never left partially written.
+// If path is an existing directory, the file is saved in that directory using
+// the name from FilenameFromResponse.
+//
+// The temporary file is created in the destination's directory, so the rename
+// never crosses a filesystem boundary. If the file can't be created there, the
+// input's TempDir is used instead, and if the rename then fails because the
+// directories are on different filesystems the file is copied to path. The copy
+// isn't atomic, so a warning is written to the input's Logger.
+//
+// If path is an existing file and the input's IfModifiedSince isn't set, the
+// file's modification time is used, so the file is only downloaded again if
+// the server's copy is newer. When it isn't, ErrNotModified is returned and the
+// file is left untouched.
+//
+// When the input's SkipIfChecksumMatches is set and path is a file that already
+// matches the ExpectedChecksum, nothing is downloaded and the file is left
+// untouched.
+//
+// The input's Dest, Sink, and CloseDest are ignored. SyncDest syncs the file
+// before it is renamed to path. When the input's DryRun is set the download is
+// checked as described for DryRun, and no file is written.
+func DownloadToFile(ctx context.Context, path string, in DownloadInput) (out *DownloadOutput, err error) {
+	in.Sink = nil
+	in.CloseDest = false
+
+	if in.DryRun {
+		in.Dest = nil
+		return Download(ctx, in)
+	}
+
+	in, err = in.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	defer in.releaseClient()
+
+	if out := skipIfChecksumMatches(in, path); out != nil {
+		return out, nil
+	}
+	in.SkipIfChecksumMatches = false
+
+	// The download is reported once the file is in place.
+	startTime := time.Now()
+	metrics := &deferredMetrics{metrics: in.Metrics}
+	in.Metrics = metrics
+	defer func() {
+		metrics.report(in, startTime, err)
+	}()
+
+	var dir string
+	if info, err := os.Stat(path); err == nil {
+		if info.IsDir() {
+			dir = path
+		} else if in.IfModifiedSince.IsZero() {
+			in.IfModifiedSince = info.ModTime()
+		}
+	}
+
+	stagingDir := dir
+	if stagingDir == "" {
+		stagingDir = filepath.Dir(path)
+	}
+
+	var resp *http.Response
+	validate := in.ValidateResponse
+	in.ValidateResponse = func(r *http.Response) error {
+		resp = r
+		if validate != nil {
+			return validate(r)
+		}
+		return nil
+	}
+
+	tmpFile, err := createTemp(stagingDir, in.TempPattern)
+	if err != nil {
+		in.logf("cargo: unable to stage download in %s, using %s instead: %v", stagingDir, in.TempDir, err)
+
+		tmpFile, err = createTemp(in.TempDir, in.TempPattern)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer func() {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+	}()
+
+	in.Dest = tmpFile
+
+	out, err = Download(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return nil, err
+	}
+
+	if dir != "" {
+		name := FilenameFromResponse(resp, in.Source)
+		if name == "" {
+			return nil, ErrMissingFilename
+		}
+		path = filepath.Join(dir, name)
+	}
+
+	mode := in.FileMode
+	if mode == 0 {
+		mode = defaultFileMode
+	}
+	if err := os.Chmod(tmpFile.Name(), mode); err != nil {
+		return nil, err
+	}
+
+	if err := moveFile(in, tmpFile.Name(), path, mode); err != nil {
+		return nil, checkDiskFull(err, path)
+	}
+
+	return out, nil
+}
+
+// DownloadTemp downloads the source to a new temporary file, and returns the
+// file's path. The file is kept once the download completes, and ownership of
+// it passes to the caller, who is responsible for removing it. If the download
+// fails the file is removed, and an empty path is returned.
+//
+// The file is created in the TempDir using the TempPattern, and the download is
+// read directly into it, with the validators and checksums applied as usual.
+// The options' Dest, Sink, DestTransform, CompressDest, CloseDest, VerifyCopy,
+// StagingFile, and DryRun are ignored. SyncDest syncs the file before it is
+// returned. The DownloadOutput's BytesWritten is the size of the file.
+func DownloadTemp(ctx context.Context, source *url.URL, opts ...Option) (path string, out *DownloadOutput, err error) {
+	in := DownloadInput{Source: source}
+	for _, opt := range opts {
+		opt(&in)
+	}
+
+	in, err = in.withDefaults()
+	if err != nil {
+		return "", nil, err
+	}
+	defer in.releaseClient()
+
+	// The download is reported once the file is synced and closed.
+	startTime := time.Now()
+	metrics := &deferredMetrics{metrics: in.Metrics}
+	in.Metrics = metrics
+	defer func() {
+		metrics.report(in, startTime, err)
+	}()
+
+	tmpFile, err := createTemp(in.TempDir, in.TempPattern)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The file is used as the staging, and is kept rather than copied.
+	in.StagingFile = tmpFile
+	in.keepStaged = true
+	in.Dest = io.Discard
+	in.Sink = nil
+	in.DestTransform = nil
+	in.CompressDest = false
+	in.CloseDest = false
+	in.VerifyCopy = false
+	in.DryRun = false
+
+	syncFile := in.SyncDest
+	in.SyncDest = false
+
+	out, err = Download(ctx, in)
+	if err == nil && syncFile {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return "", nil, err
+	}
+
+	return tmpFile.Name(), out, nil
+}
+
+// defaultFileMode is the permissions of the file written by DownloadToFile when
+// the input doesn't set a FileMode.
+const defaultFileMode os.FileMode = 0644
+
+// moveFile renames src to dst, falling back to copying the file if they are on
+// different filesystems, in which case dst is given the mode.
+func moveFile(in DownloadInput, src, dst string, mode os.FileMode) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in.logf("cargo: unable to rename %s to %s across filesystems, copying instead; the write is not atomic", src, dst)
+
+	return copyFile(src, dst, mode)
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := dstFile.Chmod(mode); err != nil {
+		dstFile.Close()
+		return err
+	}
+
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		dstFile.Close()
+		return err
+	}
+	if err := dstFile.Sync(); err != nil {
+		dstFile.Close()
+		return err
+	}
+
+	return dstFile.Close()
+}
+
+// FilenameFromResponse returns the filename for a downloaded file. The name is
+// taken from the response's Content-Disposition header (RFC 6266), preferring
+// the extended "filename*" parameter, and falls back to the last segment of the
+// URL's path.
+//
+// Any directory components are removed from the name, so it is always safe to
+// join with a directory. If no name can be determined an empty string is
+// returned.
+func FilenameFromResponse(resp *http.Response, fallbackURL *url.URL) string {
+	if resp != nil {
+		if _, params, err := mime.ParseMediaType(resp.Header.Get(`Content-Disposition`)); err == nil {
+			// mime.ParseMediaType decodes the RFC 5987 "filename*" parameter, and
+			// prefers it over "filename" when both are present.
+			if name := sanitizeFilename(params[`filename`]); name != "" {
+				return name
+			}
+		}
+	}
+
+	if fallbackURL != nil {
+		return sanitizeFilename(path.Base(fallbackURL.Path))
+	}
+
+	return ""
+}
+
+// sanitizeFilename strips any directory components from the name, guarding
+// against path traversal. An empty string is returned if nothing usable
+// remains.
+func sanitizeFilename(name string) string {
+	name = strings.ReplaceAll(name, `\`, `/`)
+	name = path.Base(name)
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+
+	switch name {
+	case "", ".", "..", "/":
+		return ""
+	default:
+		return name
+	}
+}
@@ -0,0 +1,213 @@
+package cargo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilenameFromResponse(t *testing.T) {
+	fallback, _ := url.Parse(`https://example.com/files/archive.tar.gz?version=1`)
+
+	tests := map[string]struct {
+		header   string
+		expected string
+	}{
+		`no header`:            {``, `archive.tar.gz`},
+		`filename`:             {`attachment; filename="report.pdf"`, `report.pdf`},
+		`extended filename`:    {`attachment; filename="fallback.txt"; filename*=UTF-8''%E2%82%AC%20rates.txt`, `€ rates.txt`},
+		`path traversal`:       {`attachment; filename="../../etc/passwd"`, `passwd`},
+		`windows traversal`:    {`attachment; filename="..\\..\\boot.ini"`, `boot.ini`},
+		`dot dot`:              {`attachment; filename=".."`, `archive.tar.gz`},
+		`invalid disposition`:  {`attachment; filename`, `archive.tar.gz`},
+		`inline with filename`: {`inline; filename=image.png`, `image.png`},
+	}
+
+	for name, test := range tests {
+		t.Run(`given `+name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if test.header != `` {
+				resp.Header.Set(`Content-Disposition`, test.header)
+			}
+
+			assert.Equal(t, test.expected, cargo.FilenameFromResponse(resp, fallback))
+		})
+	}
+}
+
+func TestDownloadToFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Disposition`, `attachment; filename="data.txt"`)
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL + `/download`)
+
+	t.Run(`given a file path`, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), `out.txt`)
+
+		_, err := cargo.DownloadToFile(context.Background(), path, cargo.DownloadInput{Source: source})
+
+		require.NoError(t, err)
+
+		content, _ := os.ReadFile(path)
+		assert.Equal(t, `hello`, string(content))
+	})
+
+	t.Run(`given a directory`, func(t *testing.T) {
+		dir := t.TempDir()
+
+		_, err := cargo.DownloadToFile(context.Background(), dir, cargo.DownloadInput{Source: source})
+
+		require.NoError(t, err)
+
+		content, _ := os.ReadFile(filepath.Join(dir, `data.txt`))
+		assert.Equal(t, `hello`, string(content))
+	})
+
+	t.Run(`given a file mode`, func(t *testing.T) {
+		if runtime.GOOS == `windows` {
+			t.Skip(`file modes are not supported on windows`)
+		}
+
+		dir := t.TempDir()
+
+		_, err := cargo.DownloadToFile(context.Background(), filepath.Join(dir, `default`), cargo.DownloadInput{Source: source})
+		require.NoError(t, err)
+
+		_, err = cargo.DownloadToFile(context.Background(), filepath.Join(dir, `exec`), cargo.DownloadInput{Source: source, FileMode: 0755})
+		require.NoError(t, err)
+
+		info, err := os.Stat(filepath.Join(dir, `default`))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+		info, err = os.Stat(filepath.Join(dir, `exec`))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	})
+}
+
+func TestDownloadToFileStaging(t *testing.T) {
+	dir := t.TempDir()
+	tempDir := t.TempDir()
+
+	var staged []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	_, err := cargo.DownloadToFile(context.Background(), filepath.Join(dir, `out.txt`), cargo.DownloadInput{
+		Source:  source,
+		TempDir: tempDir,
+		OnResponse: func(*http.Response) {
+			staged, _ = filepath.Glob(filepath.Join(dir, `cargo-download-*`))
+		},
+	})
+
+	require.NoError(t, err)
+
+	assert.Len(t, staged, 1)
+
+	content, _ := os.ReadFile(filepath.Join(dir, `out.txt`))
+	assert.Equal(t, `hello`, string(content))
+}
+
+func TestDownloadToFileIfModifiedSince(t *testing.T) {
+	modified := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, ``, modified, strings.NewReader(`remote`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	download := func(mtime time.Time) (string, error) {
+		path := filepath.Join(t.TempDir(), `out.txt`)
+		require.NoError(t, os.WriteFile(path, []byte(`local`), 0600))
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+
+		_, err := cargo.DownloadToFile(context.Background(), path, cargo.DownloadInput{Source: source})
+
+		content, _ := os.ReadFile(path)
+		return string(content), err
+	}
+
+	t.Run(`given a local file newer than the remote`, func(t *testing.T) {
+		content, err := download(modified.Add(time.Hour))
+
+		assert.ErrorIs(t, err, cargo.ErrNotModified)
+		assert.Equal(t, `local`, content)
+	})
+
+	t.Run(`given a local file older than the remote`, func(t *testing.T) {
+		content, err := download(modified.Add(-time.Hour))
+
+		require.NoError(t, err)
+
+		assert.Equal(t, `remote`, content)
+	})
+}
+
+func TestDownloadTemp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == `/missing` {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	t.Run(`given a successful download`, func(t *testing.T) {
+		tempDir := t.TempDir()
+		source, _ := url.Parse(server.URL)
+
+		path, out, err := cargo.DownloadTemp(context.Background(), source, func(in *cargo.DownloadInput) {
+			in.TempDir = tempDir
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, tempDir, filepath.Dir(path))
+		assert.Equal(t, int64(5), out.FileSize)
+		assert.Equal(t, int64(5), out.BytesWritten)
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, `hello`, string(content))
+	})
+
+	t.Run(`given a failed download`, func(t *testing.T) {
+		tempDir := t.TempDir()
+		source, _ := url.Parse(server.URL + `/missing`)
+
+		path, _, err := cargo.DownloadTemp(context.Background(), source, func(in *cargo.DownloadInput) {
+			in.TempDir = tempDir
+			in.ValidateResponse = cargo.ValidateStatusCodeEqual(http.StatusOK)
+		})
+
+		require.Error(t, err)
+		assert.Empty(t, path)
+
+		entries, _ := os.ReadDir(tempDir)
+		assert.Empty(t, entries)
+	})
+}
@@ -2,7 +2,10 @@ module github.com/maddiesch/go-cargo
 
 go 1.17
 
-require github.com/stretchr/testify v1.7.0
+require (
+	github.com/stretchr/testify v1.7.0
+	golang.org/x/net v0.11.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
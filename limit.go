@@ -0,0 +1,119 @@
+package cargo
+
+import (
+	"fmt"
+	"io"
+)
+
+// ExceededLimitError is the error returned when more data is written than a
+// limit allows, such as a download larger than DownloadInput.MaxBytes.
+type ExceededLimitError struct {
+	Limit int64 // The maximum number of bytes allowed
+}
+
+func (e *ExceededLimitError) Error() string {
+	return fmt.Sprintf("exceeded the limit of %d bytes", e.Limit)
+}
+
+// ContentLengthMismatchError is the error returned when the Content-Length of
+// a response doesn't match DownloadInput.ExpectContentLength.
+type ContentLengthMismatchError struct {
+	Expected int64 // The expected Content-Length
+	Actual   int64 // The response's Content-Length, or -1 if it is unknown
+}
+
+func (e *ContentLengthMismatchError) Error() string {
+	if e.Actual < 0 {
+		return fmt.Sprintf("missing content length, expected %d bytes", e.Expected)
+	}
+	return fmt.Sprintf("content length mismatch: expected %d bytes, got %d", e.Expected, e.Actual)
+}
+
+// DecompressedSizeError is the error returned when the size of the
+// decompressed data doesn't match DownloadInput.ExpectDecompressedSize.
+type DecompressedSizeError struct {
+	Expected int64 // The expected decompressed size
+	Actual   int64 // The size of the data after decompression
+}
+
+func (e *DecompressedSizeError) Error() string {
+	return fmt.Sprintf("decompressed size mismatch: expected %d bytes, got %d", e.Expected, e.Actual)
+}
+
+// LimitedWriter returns a writer that writes to w until max bytes have been
+// written. A write that would go past the limit writes the bytes that still
+// fit, and returns an *ExceededLimitError, as does every following write.
+// Writing exactly max bytes succeeds. It panics if max is negative.
+func LimitedWriter(w io.Writer, max int64) io.Writer {
+	if max < 0 {
+		panic("cargo: negative LimitedWriter limit")
+	}
+	return &limitedWriter{w: w, max: max, remaining: max}
+}
+
+type limitedWriter struct {
+	w         io.Writer
+	max       int64
+	remaining int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= l.remaining {
+		n, err := l.w.Write(p)
+		l.remaining -= int64(n)
+		return n, err
+	}
+	if l.remaining <= 0 {
+		return 0, &ExceededLimitError{Limit: l.max}
+	}
+
+	n, err := l.w.Write(p[:l.remaining])
+	l.remaining -= int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, &ExceededLimitError{Limit: l.max}
+}
+
+// withMaxBytes limits the writer to the input's MaxBytes, less the offset of
+// data that was already received. Once the offset reaches MaxBytes nothing
+// more can be written, and the limit is reported as MaxBytes.
+func withMaxBytes(in DownloadInput, w io.Writer, offset int64) io.Writer {
+	if in.MaxBytes <= 0 {
+		return w
+	}
+
+	remaining := in.MaxBytes - offset
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &limitedWriter{w: w, max: in.MaxBytes, remaining: remaining}
+}
+
+// checkMaxBytes returns an *ExceededLimitError if the announced size of the
+// download is larger than the input's MaxBytes, so it fails before the body is
+// read.
+func checkMaxBytes(in DownloadInput, size int64) error {
+	if in.MaxBytes > 0 && size > in.MaxBytes {
+		return &ExceededLimitError{Limit: in.MaxBytes}
+	}
+	return nil
+}
+
+// checkContentLength returns a *ContentLengthMismatchError if the length
+// doesn't match the input's ExpectContentLength.
+func checkContentLength(in DownloadInput, length int64) error {
+	if in.ExpectContentLength > 0 && length != in.ExpectContentLength {
+		return &ContentLengthMismatchError{Expected: in.ExpectContentLength, Actual: length}
+	}
+	return nil
+}
+
+// checkDecompressedSize returns a *DecompressedSizeError if the size doesn't
+// match the input's ExpectDecompressedSize.
+func checkDecompressedSize(in DownloadInput, size int64) error {
+	if in.ExpectDecompressedSize > 0 && size != in.ExpectDecompressedSize {
+		return &DecompressedSizeError{Expected: in.ExpectDecompressedSize, Actual: size}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitedWriter(t *testing.T) {
+	t.Run(`given exactly the limit`, func(t *testing.T) {
+		var buf bytes.Buffer
+		w := cargo.LimitedWriter(&buf, 10)
+
+		n, err := w.Write([]byte(`01234`))
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+
+		n, err = w.Write([]byte(`56789`))
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+
+		assert.Equal(t, `0123456789`, buf.String())
+	})
+
+	t.Run(`given one byte over the limit`, func(t *testing.T) {
+		var buf bytes.Buffer
+		w := cargo.LimitedWriter(&buf, 10)
+
+		n, err := w.Write([]byte(`0123456789a`))
+
+		var limitErr *cargo.ExceededLimitError
+		require.True(t, errors.As(err, &limitErr))
+
+		assert.Equal(t, int64(10), limitErr.Limit)
+		assert.Equal(t, 10, n)
+		assert.Equal(t, `0123456789`, buf.String())
+
+		n, err = w.Write([]byte(`b`))
+		assert.True(t, errors.As(err, &limitErr))
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run(`given a negative limit`, func(t *testing.T) {
+		assert.Panics(t, func() {
+			cargo.LimitedWriter(&bytes.Buffer{}, -1)
+		})
+	})
+}
+
+func TestDownloadMaxBytes(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 100)
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == `/chunked` {
+			// Flushing before the body is complete forces a chunked response, without
+			// a Content-Length.
+			w.(http.Flusher).Flush()
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	download := func(path string, max int64) (*bytes.Buffer, error) {
+		source, _ := url.Parse(server.URL + path)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:   source,
+			Dest:     &buf,
+			MaxBytes: max,
+			Retry:    &cargo.RetryPolicy{MaxAttempts: 3},
+		})
+		return &buf, err
+	}
+
+	t.Run(`given a file within the limit`, func(t *testing.T) {
+		buf, err := download(`/`, int64(len(data)))
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+	})
+
+	t.Run(`given a Content-Length over the limit`, func(t *testing.T) {
+		requests = 0
+
+		buf, err := download(`/`, 100)
+
+		var limitErr *cargo.ExceededLimitError
+		require.True(t, errors.As(err, &limitErr))
+
+		assert.Equal(t, 1, requests)
+		assert.Equal(t, 0, buf.Len())
+	})
+
+	t.Run(`given a chunked body over the limit`, func(t *testing.T) {
+		buf, err := download(`/chunked`, 100)
+
+		var limitErr *cargo.ExceededLimitError
+		require.True(t, errors.As(err, &limitErr))
+
+		assert.Equal(t, 0, buf.Len())
+	})
+
+	t.Run(`given resumed data already over the limit`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(`Content-Range`, `bytes 50-99/*`)
+			w.WriteHeader(http.StatusPartialContent)
+			w.(http.Flusher).Flush()
+			w.Write(data[50:100])
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:     source,
+			Dest:       &buf,
+			ResumeFrom: bytes.NewReader(data[:50]),
+			MaxBytes:   10,
+		})
+
+		var limitErr *cargo.ExceededLimitError
+		require.True(t, errors.As(err, &limitErr))
+
+		assert.Equal(t, int64(10), limitErr.Limit)
+		assert.Equal(t, 0, buf.Len())
+	})
+}
+
+func TestDownloadExpectContentLength(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 100)
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == `/chunked` {
+			w.(http.Flusher).Flush()
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	download := func(path string, expected int64) (*bytes.Buffer, error) {
+		source, _ := url.Parse(server.URL + path)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:              source,
+			Dest:                &buf,
+			ExpectContentLength: expected,
+			Retry:               &cargo.RetryPolicy{MaxAttempts: 3},
+		})
+		return &buf, err
+	}
+
+	t.Run(`given a matching Content-Length`, func(t *testing.T) {
+		buf, err := download(`/`, int64(len(data)))
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+	})
+
+	t.Run(`given a different Content-Length`, func(t *testing.T) {
+		requests = 0
+
+		buf, err := download(`/`, 2048)
+
+		var lengthErr *cargo.ContentLengthMismatchError
+		require.True(t, errors.As(err, &lengthErr))
+
+		assert.Equal(t, int64(2048), lengthErr.Expected)
+		assert.Equal(t, int64(len(data)), lengthErr.Actual)
+		assert.Equal(t, 1, requests)
+		assert.Equal(t, 0, buf.Len())
+	})
+
+	t.Run(`given a missing Content-Length`, func(t *testing.T) {
+		_, err := download(`/chunked`, int64(len(data)))
+
+		var lengthErr *cargo.ContentLengthMismatchError
+		require.True(t, errors.As(err, &lengthErr))
+
+		assert.Equal(t, int64(-1), lengthErr.Actual)
+	})
+}
@@ -0,0 +1,43 @@
+package cargo
+
+import "context"
+
+// copyBufferSize is the size of the buffer each read of a response body holds
+// while it runs.
+const copyBufferSize = 32 * 1024
+
+// MemoryBudget bounds the memory held by the read buffers of every download it
+// is given to, through DownloadInput.MemoryBudget. Each response body being
+// read, including each range of a parallel download, holds a 32KB buffer, so a
+// batch that fans out to many ranges can hold a lot of memory at once. A read
+// that would exceed the budget waits until another one completes. It is safe
+// for concurrent use.
+type MemoryBudget struct {
+	slots chan struct{}
+}
+
+// NewMemoryBudget returns a budget allowing bytes of read buffers to be held at
+// once. A budget smaller than a single buffer still allows one read at a time.
+func NewMemoryBudget(bytes int64) *MemoryBudget {
+	n := bytes / copyBufferSize
+	if n < 1 {
+		n = 1
+	}
+	return &MemoryBudget{slots: make(chan struct{}, n)}
+}
+
+// acquire blocks until a read buffer fits in the budget, or the context is
+// done, and returns the function releasing it. A nil budget doesn't bound
+// anything.
+func (b *MemoryBudget) acquire(ctx context.Context) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+		return func() { <-b.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
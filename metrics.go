@@ -0,0 +1,198 @@
+package cargo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Metrics receives a summary of every download, for example to export them to
+// a monitoring system without Cargo depending on its client library.
+type Metrics interface {
+	ObserveDownload(DownloadMetrics)
+}
+
+// MetricsByteCounter is an optional interface for a Metrics, which is called
+// with the bytes of the response body as they are read, for live counters.
+type MetricsByteCounter interface {
+	AddBytes(n int64)
+}
+
+// NopMetrics is a Metrics that discards everything. It is the default for
+// DownloadInput.Metrics.
+var NopMetrics Metrics = nopMetrics{}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveDownload(DownloadMetrics) {}
+
+// DownloadMetrics is the summary of a completed or failed download.
+type DownloadMetrics struct {
+	Source   *url.URL
+	Duration time.Duration
+	Attempts int // The number of attempts made, including retries
+
+	// The number of bytes read from the response body, for a completed
+	// download. Failed downloads report 0, the bytes they read are only counted
+	// by a MetricsByteCounter.
+	BytesReceived int64
+
+	// The status code of the final response, or the status code of an
+	// HTTPResponseError. It is 0 when no response was received.
+	StatusCode int
+
+	Err        error      // The download's error, or nil if it completed
+	ErrorClass ErrorClass // The class of Err
+
+	// True if the download was skipped because the file already matched the
+	// expected checksum, see DownloadInput.SkipIfChecksumMatches. No request was
+	// made, so Attempts is 0.
+	Skipped bool
+}
+
+// ErrorClass is a coarse classification of a download's error, suitable as a
+// metric label.
+type ErrorClass string
+
+// The classes returned by ClassifyError.
+const (
+	ErrorClassNone        ErrorClass = ""
+	ErrorClassCanceled    ErrorClass = "canceled"
+	ErrorClassTimeout     ErrorClass = "timeout"
+	ErrorClassHTTP        ErrorClass = "http"
+	ErrorClassNotModified ErrorClass = "not_modified"
+	ErrorClassIntegrity   ErrorClass = "integrity"
+	ErrorClassLimit       ErrorClass = "limit"
+	ErrorClassDisk        ErrorClass = "disk"
+	ErrorClassNetwork     ErrorClass = "network"
+	ErrorClassOther       ErrorClass = "other"
+)
+
+// ClassifyError returns the class of a download's error, or ErrorClassNone for
+// a nil error.
+func ClassifyError(err error) ErrorClass {
+	var (
+		respErr     *HTTPResponseError
+		redirectErr *TooManyRedirectsError
+		checksumErr *ChecksumError
+		truncErr    *TruncatedError
+		chunkErr    *ChunkAssemblyError
+		copyErr     *CopyVerificationError
+		sizeErr     *DecompressedSizeError
+		limitErr    *ExceededLimitError
+		lengthErr   *ContentLengthMismatchError
+		slowErr     *SlowDownloadError
+		diskErr     *DiskFullError
+		netErr      net.Error
+	)
+
+	switch {
+	case err == nil:
+		return ErrorClassNone
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrAttemptTimeout), errors.As(err, &slowErr):
+		return ErrorClassTimeout
+	case errors.Is(err, ErrNotModified):
+		return ErrorClassNotModified
+	case errors.As(err, &respErr), errors.As(err, &redirectErr):
+		return ErrorClassHTTP
+	case errors.As(err, &checksumErr), errors.As(err, &truncErr), errors.As(err, &chunkErr), errors.As(err, &copyErr),
+		errors.As(err, &sizeErr):
+		return ErrorClassIntegrity
+	case errors.As(err, &limitErr), errors.As(err, &lengthErr), errors.Is(err, ErrBelowMinBytes):
+		return ErrorClassLimit
+	case errors.As(err, &diskErr):
+		return ErrorClassDisk
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return ErrorClassTimeout
+		}
+		return ErrorClassNetwork
+	default:
+		return ErrorClassOther
+	}
+}
+
+// observeDownload reports a completed or failed download to the input's
+// Metrics.
+func observeDownload(in DownloadInput, startTime time.Time, attempts int, out *DownloadOutput, err error) {
+	m := DownloadMetrics{
+		Source:     in.Source,
+		Duration:   time.Since(startTime),
+		Attempts:   attempts,
+		Err:        err,
+		ErrorClass: ClassifyError(err),
+	}
+	if out != nil {
+		m.Duration = out.Duration
+		m.BytesReceived = out.BytesReceived
+		m.StatusCode = out.StatusCode
+		m.Skipped = out.Skipped
+	}
+
+	var respErr *HTTPResponseError
+	if errors.As(err, &respErr) {
+		m.StatusCode = respErr.StatusCode
+	}
+
+	in.Metrics.ObserveDownload(m)
+}
+
+// deferredMetrics holds the download reported by Download to the Metrics it
+// wraps, for callers like DownloadToFile that can still fail once Download
+// returns, so the download is only reported once its final result is known.
+type deferredMetrics struct {
+	metrics  Metrics
+	observed *DownloadMetrics
+}
+
+func (d *deferredMetrics) ObserveDownload(m DownloadMetrics) {
+	d.observed = &m
+}
+
+func (d *deferredMetrics) AddBytes(n int64) {
+	if counter, ok := d.metrics.(MetricsByteCounter); ok {
+		counter.AddBytes(n)
+	}
+}
+
+// report reports the download to the wrapped Metrics, failing with err if it
+// failed after Download returned, or before it was called.
+func (d *deferredMetrics) report(in DownloadInput, startTime time.Time, err error) {
+	in.Metrics = d.metrics
+	if d.observed == nil {
+		observeDownload(in, startTime, 0, nil, err)
+		return
+	}
+
+	m := *d.observed
+	if err != nil && m.Err == nil {
+		m.BytesReceived = 0
+		m.Err = err
+		m.ErrorClass = ClassifyError(err)
+	}
+	d.metrics.ObserveDownload(m)
+}
+
+// withMetricsBytes adds the input's Metrics to the writer the response body is
+// tee'd to, if it is a MetricsByteCounter.
+func withMetricsBytes(in DownloadInput, w io.Writer) io.Writer {
+	counter, ok := in.Metrics.(MetricsByteCounter)
+	if !ok {
+		return w
+	}
+	return io.MultiWriter(w, metricsWriter{counter})
+}
+
+type metricsWriter struct {
+	counter MetricsByteCounter
+}
+
+func (w metricsWriter) Write(b []byte) (int, error) {
+	w.counter.AddBytes(int64(len(b)))
+	return len(b), nil
+}
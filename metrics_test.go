@@ -0,0 +1,179 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type metricsRecorder struct {
+	mu        sync.Mutex
+	downloads []cargo.DownloadMetrics
+	bytes     int64
+}
+
+func (m *metricsRecorder) ObserveDownload(d cargo.DownloadMetrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.downloads = append(m.downloads, d)
+}
+
+func (m *metricsRecorder) AddBytes(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.bytes += n
+}
+
+func TestDownloadMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == `/missing` {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`Hello World`))
+	}))
+	defer server.Close()
+
+	t.Run(`given a completed download`, func(t *testing.T) {
+		metrics := &metricsRecorder{}
+		source, _ := url.Parse(server.URL)
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:  source,
+			Dest:    &bytes.Buffer{},
+			Metrics: metrics,
+		})
+
+		require.NoError(t, err)
+		require.Len(t, metrics.downloads, 1)
+
+		d := metrics.downloads[0]
+		assert.Equal(t, source, d.Source)
+		assert.Equal(t, 1, d.Attempts)
+		assert.Equal(t, int64(11), d.BytesReceived)
+		assert.Equal(t, http.StatusOK, d.StatusCode)
+		assert.NoError(t, d.Err)
+		assert.Equal(t, cargo.ErrorClassNone, d.ErrorClass)
+		assert.Equal(t, int64(11), metrics.bytes)
+	})
+
+	t.Run(`given a failed download`, func(t *testing.T) {
+		metrics := &metricsRecorder{}
+		source, _ := url.Parse(server.URL + `/missing`)
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:           source,
+			Dest:             &bytes.Buffer{},
+			ValidateResponse: cargo.ValidateStatusCodeEqual(http.StatusOK),
+			Metrics:          metrics,
+		})
+
+		require.Error(t, err)
+		require.Len(t, metrics.downloads, 1)
+
+		d := metrics.downloads[0]
+		assert.Equal(t, http.StatusNotFound, d.StatusCode)
+		assert.Equal(t, err, d.Err)
+		assert.Equal(t, cargo.ErrorClassHTTP, d.ErrorClass)
+	})
+
+	t.Run(`given ranges of a server ignoring ranges`, func(t *testing.T) {
+		metrics := &metricsRecorder{}
+		source, _ := url.Parse(server.URL)
+
+		var buf bytes.Buffer
+		_, err := cargo.DownloadRanges(context.Background(), source, []cargo.RangeSpec{
+			{Start: 6, End: 10, Dest: &buf},
+		}, func(in *cargo.DownloadInput) {
+			in.Metrics = metrics
+		})
+
+		require.NoError(t, err)
+		require.Len(t, metrics.downloads, 1)
+
+		d := metrics.downloads[0]
+		assert.Equal(t, `World`, buf.String())
+		assert.Equal(t, 1, d.Attempts)
+		assert.Equal(t, int64(11), d.BytesReceived)
+		assert.Equal(t, int64(11), metrics.bytes)
+		assert.Equal(t, http.StatusOK, d.StatusCode)
+	})
+
+	t.Run(`given an opened body`, func(t *testing.T) {
+		metrics := &metricsRecorder{}
+		source, _ := url.Parse(server.URL)
+
+		body, _, err := cargo.Open(context.Background(), source, func(in *cargo.DownloadInput) {
+			in.Metrics = metrics
+		})
+		require.NoError(t, err)
+
+		_, err = io.Copy(io.Discard, body)
+		require.NoError(t, err)
+		assert.Empty(t, metrics.downloads)
+
+		require.NoError(t, body.Close())
+		require.NoError(t, body.Close())
+		require.Len(t, metrics.downloads, 1)
+
+		d := metrics.downloads[0]
+		assert.Equal(t, 1, d.Attempts)
+		assert.Equal(t, int64(11), d.BytesReceived)
+		assert.Equal(t, http.StatusOK, d.StatusCode)
+		assert.NoError(t, d.Err)
+	})
+
+	t.Run(`given a file that can't be moved into place`, func(t *testing.T) {
+		metrics := &metricsRecorder{}
+		source, _ := url.Parse(server.URL)
+
+		_, err := cargo.DownloadToFile(context.Background(), filepath.Join(t.TempDir(), `missing`, `file.txt`), cargo.DownloadInput{
+			Source:  source,
+			Metrics: metrics,
+			TempDir: t.TempDir(),
+		})
+
+		require.Error(t, err)
+		require.Len(t, metrics.downloads, 1)
+
+		d := metrics.downloads[0]
+		assert.Equal(t, 1, d.Attempts)
+		assert.Equal(t, err, d.Err)
+		assert.Equal(t, cargo.ClassifyError(err), d.ErrorClass)
+	})
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err   error
+		class cargo.ErrorClass
+	}{
+		{nil, cargo.ErrorClassNone},
+		{context.Canceled, cargo.ErrorClassCanceled},
+		{fmt.Errorf("read: %w", context.DeadlineExceeded), cargo.ErrorClassTimeout},
+		{cargo.ErrNotModified, cargo.ErrorClassNotModified},
+		{&cargo.HTTPResponseError{StatusCode: http.StatusForbidden}, cargo.ErrorClassHTTP},
+		{&cargo.ChecksumError{Algorithm: `sha256`}, cargo.ErrorClassIntegrity},
+		{&cargo.ExceededLimitError{Limit: 10}, cargo.ErrorClassLimit},
+		{&cargo.RetryError{Attempts: 3, Err: &cargo.TruncatedError{Received: 1, Expected: 2}}, cargo.ErrorClassIntegrity},
+		{errors.New(`unknown`), cargo.ErrorClassOther},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.class, cargo.ClassifyError(tt.err), "%v", tt.err)
+	}
+}
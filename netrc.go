@@ -0,0 +1,103 @@
+package cargo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// netrcEntry is a machine entry in a netrc file. The default entry has an empty
+// machine.
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+// parseNetrc parses the machine and default entries of a netrc file. Macro
+// definitions are skipped, and unknown tokens are ignored.
+func parseNetrc(data string) []netrcEntry {
+	var (
+		entries []netrcEntry
+		current *netrcEntry
+	)
+
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for j := 0; j < len(fields); j++ {
+			next := func() string {
+				if j+1 < len(fields) {
+					j++
+					return fields[j]
+				}
+				return ""
+			}
+
+			switch fields[j] {
+			case "machine":
+				entries = append(entries, netrcEntry{machine: next()})
+				current = &entries[len(entries)-1]
+			case "default":
+				entries = append(entries, netrcEntry{})
+				current = &entries[len(entries)-1]
+			case "login":
+				if current != nil {
+					current.login = next()
+				}
+			case "password":
+				if current != nil {
+					current.password = next()
+				}
+			case "macdef":
+				// A macro runs until the next blank line.
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				j = len(fields)
+			}
+		}
+	}
+
+	return entries
+}
+
+// netrcCredentials returns the credentials for the host from the netrc file at
+// path, or the user's ~/.netrc if path is empty. A machine entry matching the
+// host is preferred over the default entry. A missing file has no entries.
+func netrcCredentials(path, host string) (*BasicAuth, error) {
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fallback *BasicAuth
+	for _, entry := range parseNetrc(string(data)) {
+		auth := &BasicAuth{Username: entry.login, Password: entry.password}
+		switch {
+		case entry.machine == "" && fallback == nil:
+			fallback = auth
+		case entry.machine != "" && strings.EqualFold(entry.machine, host):
+			return auth, nil
+		}
+	}
+
+	return fallback, nil
+}
@@ -0,0 +1,195 @@
+package cargo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Option configures the DownloadInput used by the functions that take their
+// source directly, rather than a full DownloadInput.
+type Option func(*DownloadInput)
+
+// ResourceInfo contains the metadata of the response for a downloaded resource.
+type ResourceInfo struct {
+	URL           *url.URL    // The final URL, after any redirects
+	StatusCode    int         // The response status code
+	ContentLength int64       // The expected size, or -1 if it is unknown
+	ContentType   string      // The Content-Type header
+	ETag          string      // The ETag header
+	LastModified  string      // The Last-Modified header
+	Header        http.Header // All of the response headers
+}
+
+func newResourceInfo(in DownloadInput, resp *http.Response) *ResourceInfo {
+	return &ResourceInfo{
+		URL:           resp.Request.URL,
+		StatusCode:    resp.StatusCode,
+		ContentLength: in.ContentLength(resp),
+		ContentType:   resp.Header.Get("Content-Type"),
+		ETag:          resp.Header.Get("ETag"),
+		LastModified:  resp.Header.Get("Last-Modified"),
+		Header:        resp.Header,
+	}
+}
+
+// Open performs the request for the source and returns a reader over the
+// response body, along with the response metadata. It is the streaming
+// counterpart to Download, for callers that want to consume the body
+// themselves. Closing the reader closes the response body.
+//
+// The request is created, retried, and validated using the same options as
+// Download. Reads are reported to the ProgressHandler and paced by the
+// RateLimit, and the body is sniffed if RejectHTMLSniff is set. The remaining
+// options that control the read, like ReadTimeout and AttemptTimeout, are
+// ignored as the caller controls the read, except for the Deadline, which also
+// bounds reading the body. The context must remain valid until the body has
+// been read. If a Cache is set it is consulted, but it isn't
+// updated since Open can't know if the body is read successfully. The download
+// is reported to the Metrics when the reader is closed, failing with the first
+// error returned by a read, if any.
+func Open(ctx context.Context, source *url.URL, opts ...Option) (io.ReadCloser, *ResourceInfo, error) {
+	in := DownloadInput{Source: source}
+	for _, opt := range opts {
+		opt(&in)
+	}
+	in.AttemptTimeout = 0
+
+	in, err := in.withDefaults()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := withDeadline(ctx, in)
+	release := func() {
+		cancel()
+		in.releaseClient()
+	}
+
+	startTime := time.Now()
+
+	var attempts int
+	_, resp, err := readWithRetry(ctx, in, func(ctx context.Context) (int64, *http.Response, error) {
+		attempts++
+		resp, err := openAttempt(ctx, in)
+		return 0, resp, err
+	}, nil)
+	if err != nil {
+		release()
+		observeDownload(in, startTime, attempts, nil, err)
+		return nil, nil, err
+	}
+
+	contentLen := in.ExpectedSize
+	if contentLen <= 0 {
+		contentLen = in.ContentLength(resp)
+	}
+	if in.ProgressHandler != nil {
+		in.ProgressHandler.Expected(contentLen)
+	}
+
+	var body io.Reader = resp.Body
+	if in.RejectHTMLSniff {
+		if body, err = rejectHTML(body); err != nil {
+			resp.Body.Close()
+			release()
+			observeDownload(in, startTime, attempts, nil, err)
+			return nil, nil, err
+		}
+	}
+
+	return &openReader{
+		r:       io.TeeReader(body, withRateLimit(ctx, in, withMetricsBytes(in, createProgressWriter(in.ProgressHandler)))),
+		c:       resp.Body,
+		h:       in.ProgressHandler,
+		release: release,
+		observe: func(n int64, err error) {
+			var out *DownloadOutput
+			if err == nil {
+				out = &DownloadOutput{
+					Duration:      time.Since(startTime),
+					BytesReceived: n,
+					Attempts:      attempts,
+				}
+				out.setResponse(resp)
+			}
+			observeDownload(in, startTime, attempts, out, err)
+		},
+	}, newResourceInfo(in, resp), nil
+}
+
+// openAttempt sends the request and validates the response. The response body
+// is closed if the attempt fails.
+func openAttempt(ctx context.Context, in DownloadInput) (*http.Response, error) {
+	in.enterPhase(PhaseConnecting)
+
+	req, err := newRequest(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := sendRequest(in, req)
+	if err != nil {
+		return nil, err
+	}
+
+	in.enterPhase(PhaseResponse)
+
+	if err := peekResponse(ctx, resp); err != nil {
+		resp.Body.Close()
+		return resp, err
+	}
+
+	if in.conditional() && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return resp, ErrNotModified
+	}
+
+	if err := validateResponse(in, resp); err != nil {
+		resp.Body.Close()
+		return resp, err
+	}
+
+	if err := acceptResponse(ctx, resp); err != nil {
+		resp.Body.Close()
+		return resp, err
+	}
+
+	return resp, nil
+}
+
+type openReader struct {
+	r   io.Reader
+	c   io.Closer
+	h   ProgressHandler
+	n   int64
+	err error // The first error returned by a read, other than io.EOF
+
+	release func()                   // Ends the Deadline, and releases a client built for the read
+	observe func(n int64, err error) // Reports the download to the Metrics
+	closed  bool
+}
+
+func (o *openReader) Read(b []byte) (int, error) {
+	n, err := o.r.Read(b)
+	o.n += int64(n)
+	if errors.Is(err, io.EOF) {
+		completeProgress(o.h, o.n)
+	} else if err != nil && o.err == nil {
+		o.err = err
+	}
+	return n, err
+}
+
+func (o *openReader) Close() error {
+	err := o.c.Close()
+	if !o.closed {
+		o.closed = true
+		o.release()
+		o.observe(o.n, o.err)
+	}
+	return err
+}
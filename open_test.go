@@ -0,0 +1,86 @@
+package cargo_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == `/missing` {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set(`Content-Type`, `text/plain`)
+		w.Header().Set(`ETag`, `"v1"`)
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	t.Run(`given a valid source`, func(t *testing.T) {
+		source, _ := url.Parse(server.URL)
+
+		var received int64
+
+		body, info, err := cargo.Open(context.Background(), source, func(in *cargo.DownloadInput) {
+			in.ProgressHandler = cargo.ProgressHandlerFunc(func(_, r int64) {
+				received = r
+			})
+		})
+
+		require.NoError(t, err)
+		defer body.Close()
+
+		data, err := io.ReadAll(body)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, `hello`, string(data))
+		assert.Equal(t, int64(5), received)
+		assert.Equal(t, http.StatusOK, info.StatusCode)
+		assert.Equal(t, int64(5), info.ContentLength)
+		assert.Equal(t, `text/plain`, info.ContentType)
+		assert.Equal(t, `"v1"`, info.ETag)
+	})
+
+	t.Run(`given a deadline`, func(t *testing.T) {
+		stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`hel`))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}))
+		defer stalled.Close()
+
+		source, _ := url.Parse(stalled.URL)
+
+		body, _, err := cargo.Open(context.Background(), source, func(in *cargo.DownloadInput) {
+			in.Deadline = 50 * time.Millisecond
+		})
+
+		require.NoError(t, err)
+		defer body.Close()
+
+		_, err = io.ReadAll(body)
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run(`given a failed validation`, func(t *testing.T) {
+		source, _ := url.Parse(server.URL + `/missing`)
+
+		_, _, err := cargo.Open(context.Background(), source, func(in *cargo.DownloadInput) {
+			in.ValidateResponse = cargo.ValidateStatusCodeEqual(http.StatusOK)
+		})
+
+		assert.Error(t, err)
+	})
+}
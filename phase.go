@@ -0,0 +1,49 @@
+package cargo
+
+import "fmt"
+
+// Phase is a stage of a download, reported to DownloadInput.PhaseHandler.
+type Phase int
+
+const (
+	// PhaseConnecting is entered when a request is created and sent, which
+	// covers establishing the connection and waiting for the response.
+	PhaseConnecting Phase = iota
+
+	// PhaseResponse is entered when a response is received, while it is being
+	// validated.
+	PhaseResponse
+
+	// PhaseDownloading is entered when the response body starts being read.
+	PhaseDownloading
+
+	// PhaseVerifying is entered once the body has been read, while the data is
+	// checked against the expected checksum and ValidateStaged.
+	PhaseVerifying
+
+	// PhaseFinalizing is entered when the staged data is copied into Dest.
+	PhaseFinalizing
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseConnecting:
+		return "connecting"
+	case PhaseResponse:
+		return "response"
+	case PhaseDownloading:
+		return "downloading"
+	case PhaseVerifying:
+		return "verifying"
+	case PhaseFinalizing:
+		return "finalizing"
+	}
+	return fmt.Sprintf("Phase(%d)", int(p))
+}
+
+// enterPhase reports the phase to the input's PhaseHandler, if one is set.
+func (in DownloadInput) enterPhase(p Phase) {
+	if in.PhaseHandler != nil {
+		in.PhaseHandler(p)
+	}
+}
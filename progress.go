@@ -1,12 +1,28 @@
 package cargo
 
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
 // ProgressHandler defines the interface for listening for download progress
 // updates.
 type ProgressHandler interface {
 	// Expected will be called once before the request begins reading the body.
-	// The value passed will be the parsed value from the HTTP header field
-	// 'Content-Length'. If 'Content-Length' is missing or contains an invalid
-	// integer value, -1 will be given.
+	// If the download is retried it will be called again before each attempt,
+	// and any progress from the failed attempt should be discarded. The value
+	// passed will be the parsed value from the HTTP header field
+	// 'Content-Length'. If 'Content-Length' is missing, the value of the
+	// 'X-Content-Length' header or the complete length from 'Content-Range' is
+	// used instead. If none of the headers contain a valid integer value, -1 will
+	// be given. DownloadInput.ExpectedSize overrides the headers when set.
+	//
+	// For a parallel download the size reported by the HEAD request, or the
+	// ExpectedSize, is given once the ranges are planned, before any of them is
+	// requested, so the total is known before the first range's response
+	// arrives.
 	Expected(int64)
 
 	// Receive will be called every time Cargo reads data from the HTTP request.
@@ -14,25 +30,267 @@ type ProgressHandler interface {
 	Receive(int)
 }
 
+// ProgressCanceler can be implemented by a ProgressHandler that needs to abort
+// the download, for example when a user presses a cancel button. Err is checked
+// after each call to Receive, and once it returns a non-nil error the download
+// is aborted and fails with that error. Retries are never attempted for a
+// download canceled this way.
+type ProgressCanceler interface {
+	Err() error
+}
+
+// progressCanceled returns the error from the handler, if it implements
+// ProgressCanceler and has canceled the download.
+func progressCanceled(h ProgressHandler) error {
+	if c, ok := h.(ProgressCanceler); ok {
+		return c.Err()
+	}
+	return nil
+}
+
 // ProgressHandlerFunc provides a basic ProgressHandler that will call the given
 // function for each value update. The function will receive the expected number
 // of bytes to read, and the total number of bytes read up to this point.
+//
+// If the expected size was unknown (-1), the function is called once more when
+// the download completes, with the final size as the expected value.
+//
+// The handler is safe for concurrent use, such as by parallel chunk reads. The
+// reported total never decreases, and calls to the function are serialized.
 func ProgressHandlerFunc(fn func(int64, int64)) ProgressHandler {
+	return &progressHandlerFuncImpl{fn: func(expected, received int64) error {
+		fn(expected, received)
+		return nil
+	}}
+}
+
+// ProgressHandlerCancelable provides a ProgressHandler like ProgressHandlerFunc,
+// where the function can abort the download by returning an error. The download
+// fails with the first error returned.
+func ProgressHandlerCancelable(fn func(int64, int64) error) ProgressHandler {
 	return &progressHandlerFuncImpl{fn: fn}
 }
 
+// ProgressBinder can be implemented by a ProgressHandler that is shared by many
+// downloads. Bind is called with the DownloadInput's ProgressID once before the
+// download begins, and the returned handler receives the download's updates in
+// place of the shared one.
+type ProgressBinder interface {
+	Bind(id interface{}) ProgressHandler
+}
+
+// bindProgress returns the handler bound to the ID, if it implements
+// ProgressBinder.
+func bindProgress(h ProgressHandler, id interface{}) ProgressHandler {
+	if b, ok := h.(ProgressBinder); ok {
+		return b.Bind(id)
+	}
+	return h
+}
+
+// ProgressHandlerFuncID provides a ProgressHandler like ProgressHandlerFunc that
+// can be shared by many downloads, for example to route the updates to a row
+// for each download in a UI. The function also receives the download's
+// DownloadInput.ProgressID, and the progress of each download is tracked
+// separately.
+func ProgressHandlerFuncID(fn func(id interface{}, expected, received int64)) ProgressHandler {
+	return &progressHandlerFuncIDImpl{
+		progressHandlerFuncImpl: progressHandlerFuncImpl{fn: func(expected, received int64) error {
+			fn(nil, expected, received)
+			return nil
+		}},
+		fn: fn,
+	}
+}
+
+type progressHandlerFuncIDImpl struct {
+	progressHandlerFuncImpl
+	fn func(interface{}, int64, int64)
+}
+
+func (p *progressHandlerFuncIDImpl) Bind(id interface{}) ProgressHandler {
+	return &progressHandlerFuncImpl{fn: func(expected, received int64) error {
+		p.fn(id, expected, received)
+		return nil
+	}}
+}
+
 type progressHandlerFuncImpl struct {
-	expected int64
-	count    int64
-	fn       func(int64, int64)
+	expected int64 // atomic
+	count    int64 // atomic
+	fn       func(int64, int64) error
+
+	mu       sync.Mutex
+	reported int64
+	err      error
 }
 
 func (p *progressHandlerFuncImpl) Expected(i int64) {
-	p.expected = i
-	p.fn(p.expected, p.count)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	atomic.StoreInt64(&p.expected, i)
+	atomic.StoreInt64(&p.count, 0)
+	p.reported = 0
+	p.call(i, 0)
 }
 
 func (p *progressHandlerFuncImpl) Receive(i int) {
-	p.count += int64(i)
-	p.fn(p.expected, p.count)
+	total := atomic.AddInt64(&p.count, int64(i))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// A concurrent Receive may have already reported a larger total, in which
+	// case this update is stale.
+	if total <= p.reported {
+		return
+	}
+	p.reported = total
+	p.call(atomic.LoadInt64(&p.expected), total)
+}
+
+// call invokes the function, keeping the first error it returns. The caller
+// must hold the mutex.
+func (p *progressHandlerFuncImpl) call(expected, received int64) {
+	if err := p.fn(expected, received); err != nil && p.err == nil {
+		p.err = err
+	}
+}
+
+// Total reports the final size when the expected size was unknown, so the
+// function sees a completed download.
+func (p *progressHandlerFuncImpl) Total(i int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if atomic.LoadInt64(&p.expected) >= 0 {
+		return
+	}
+
+	atomic.StoreInt64(&p.expected, i)
+	if i > p.reported {
+		p.reported = i
+	}
+	p.call(i, p.reported)
+}
+
+func (p *progressHandlerFuncImpl) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// ProgressHandlerPercent provides a ProgressHandler that calls the given function
+// with the percentage of the download that has completed, from 0 to 100, and
+// the total number of bytes read up to this point. If the expected size is
+// unknown the percentage will be -1.
+func ProgressHandlerPercent(fn func(float64, int64)) ProgressHandler {
+	return ProgressHandlerFunc(func(expected, received int64) {
+		switch {
+		case expected < 0:
+			fn(-1, received)
+		case expected == 0:
+			fn(100, received)
+		default:
+			fn(math.Min(float64(received)/float64(expected)*100, 100), received)
+		}
+	})
+}
+
+// ThrottleProgress wraps the ProgressHandler so Receive updates are coalesced,
+// calling the handler's Receive at most once per interval with the total
+// received since the previous call. Calls to Expected are passed through
+// immediately, and any pending update is delivered once the read completes, so
+// the final progress is never lost.
+func ThrottleProgress(h ProgressHandler, interval time.Duration) ProgressHandler {
+	return &throttledProgressHandler{h: h, interval: interval}
+}
+
+type throttledProgressHandler struct {
+	h        ProgressHandler
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending int
+	last    time.Time
+}
+
+func (p *throttledProgressHandler) Expected(i int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending = 0
+	p.last = time.Now()
+	p.h.Expected(i)
+}
+
+func (p *throttledProgressHandler) Receive(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending += i
+	if time.Since(p.last) < p.interval {
+		return
+	}
+
+	p.h.Receive(p.pending)
+	p.pending = 0
+	p.last = time.Now()
+}
+
+// Bind throttles the handler bound by the wrapped handler, if it implements
+// ProgressBinder.
+func (p *throttledProgressHandler) Bind(id interface{}) ProgressHandler {
+	if b, ok := p.h.(ProgressBinder); ok {
+		return ThrottleProgress(b.Bind(id), p.interval)
+	}
+	return p
+}
+
+func (p *throttledProgressHandler) Total(i int64) {
+	if f, ok := p.h.(ProgressFinisher); ok {
+		f.Total(i)
+	}
+}
+
+func (p *throttledProgressHandler) Err() error {
+	return progressCanceled(p.h)
+}
+
+func (p *throttledProgressHandler) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending > 0 {
+		p.h.Receive(p.pending)
+		p.pending = 0
+	}
+	p.last = time.Now()
+}
+
+// progressFlusher is implemented by handlers that buffer updates, and is called
+// once the body has been read.
+type progressFlusher interface {
+	flush()
+}
+
+// ProgressFinisher can be implemented by a ProgressHandler to learn the final
+// size of a download once the body has been read completely. This allows a
+// handler that was given an unknown (-1) expected size to show a completed
+// state at the end.
+type ProgressFinisher interface {
+	Total(int64)
+}
+
+// completeProgress delivers any buffered updates to the handler, followed by
+// the final total if the handler implements ProgressFinisher.
+func completeProgress(h ProgressHandler, total int64) {
+	if f, ok := h.(progressFlusher); ok {
+		f.flush()
+	}
+	if f, ok := h.(ProgressFinisher); ok {
+		f.Total(total)
+	}
 }
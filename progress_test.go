@@ -0,0 +1,259 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThrottleProgress(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 100000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Length`, fmt.Sprint(len(data)))
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	var calls int
+	var received int64
+
+	_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source: source,
+		Dest:   &bytes.Buffer{},
+		ProgressHandler: cargo.ThrottleProgress(cargo.ProgressHandlerFunc(func(_, r int64) {
+			calls++
+			received = r
+		}), time.Hour),
+	})
+
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, int64(len(data)), received)
+}
+
+func TestProgressHandlerFuncConcurrent(t *testing.T) {
+	const chunks = 8
+	const chunkSize = 64 * 1024
+	const readSize = 1024
+
+	var reported []int64
+
+	h := cargo.ProgressHandlerFunc(func(_, received int64) {
+		reported = append(reported, received)
+	})
+
+	h.Expected(chunks * chunkSize)
+
+	var wg sync.WaitGroup
+	for i := 0; i < chunks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < chunkSize; n += readSize {
+				h.Receive(readSize)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.NotEmpty(t, reported)
+
+	for i := 1; i < len(reported); i++ {
+		require.GreaterOrEqual(t, reported[i], reported[i-1])
+	}
+	assert.Equal(t, int64(chunks*chunkSize), reported[len(reported)-1])
+}
+
+func TestProgressHandlerPercent(t *testing.T) {
+	var percent float64
+
+	h := cargo.ProgressHandlerPercent(func(pct float64, _ int64) {
+		percent = pct
+	})
+
+	t.Run(`given a known size`, func(t *testing.T) {
+		h.Expected(200)
+		h.Receive(50)
+
+		assert.Equal(t, float64(25), percent)
+	})
+
+	t.Run(`given an unknown size`, func(t *testing.T) {
+		h.Expected(-1)
+		h.Receive(50)
+
+		assert.Equal(t, float64(-1), percent)
+	})
+
+	t.Run(`given an empty file`, func(t *testing.T) {
+		h.Expected(0)
+
+		assert.Equal(t, float64(100), percent)
+	})
+}
+
+func TestProgressHandlerCancelable(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 100000)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	errCanceled := errors.New(`canceled by user`)
+
+	handler := cargo.ProgressHandlerCancelable(func(expected, received int64) error {
+		if received > 0 {
+			return errCanceled
+		}
+		return nil
+	})
+
+	var buf bytes.Buffer
+	_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source:          source,
+		Dest:            &buf,
+		ProgressHandler: cargo.ThrottleProgress(handler, 0),
+		Retry:           &cargo.RetryPolicy{MaxAttempts: 3, Delay: time.Millisecond},
+	})
+
+	assert.Equal(t, errCanceled, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestProgressHandlerTotal(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 10000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Flushing before the body is complete forces a chunked response, without
+		// a Content-Length.
+		w.Write(data[:10])
+		w.(http.Flusher).Flush()
+		w.Write(data[10:])
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	var updates [][2]float64
+	handler := cargo.ProgressHandlerPercent(func(pct float64, received int64) {
+		updates = append(updates, [2]float64{pct, float64(received)})
+	})
+
+	_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source:          source,
+		Dest:            &bytes.Buffer{},
+		ProgressHandler: cargo.ThrottleProgress(handler, time.Hour),
+	})
+
+	require.NoError(t, err)
+
+	require.NotEmpty(t, updates)
+	assert.Equal(t, [2]float64{-1, 0}, updates[0])
+	assert.Equal(t, [2]float64{100, float64(len(data))}, updates[len(updates)-1])
+}
+
+func TestProgressHandlerFuncID(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 1000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Length`, fmt.Sprint(len(data)))
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	var (
+		mu       sync.Mutex
+		received = map[interface{}]int64{}
+	)
+	handler := cargo.ThrottleProgress(cargo.ProgressHandlerFuncID(func(id interface{}, _, r int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		received[id] = r
+	}), time.Hour)
+
+	inputs := make([]cargo.DownloadInput, 3)
+	for i := range inputs {
+		inputs[i] = cargo.DownloadInput{
+			Source:          source,
+			Dest:            &bytes.Buffer{},
+			ProgressHandler: handler,
+			ProgressID:      i,
+		}
+	}
+
+	for _, result := range cargo.DownloadBatch(context.Background(), inputs, cargo.BatchOptions{}) {
+		require.NoError(t, result.Err)
+	}
+
+	assert.Equal(t, map[interface{}]int64{0: int64(len(data)), 1: int64(len(data)), 2: int64(len(data))}, received)
+}
+
+func TestProgressHandlerWriter(t *testing.T) {
+	t.Run(`given a known size`, func(t *testing.T) {
+		var buf bytes.Buffer
+		handler := cargo.ProgressHandlerWriter(&buf)
+
+		handler.Expected(5 * 1024 * 1024)
+		handler.Receive(1258292)
+
+		assert.Regexp(t, `^\r1\.2 MiB / 5\.0 MiB \(24%\) [0-9.]+ [KMGTPE]?i?B/s( ETA \S+)?$`, buf.String())
+
+		buf.Reset()
+		handler.Receive(100)
+
+		assert.Empty(t, buf.String(), `renders are throttled`)
+
+		handler.(cargo.ProgressFinisher).Total(5 * 1024 * 1024)
+
+		assert.Regexp(t, `^\r5\.0 MiB / 5\.0 MiB \(100%\) [0-9.]+ [KMGTPE]?i?B/s *\n$`, buf.String())
+	})
+
+	t.Run(`given an unknown size`, func(t *testing.T) {
+		data := bytes.Repeat([]byte(`0123456789`), 10000)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data[:10])
+			w.(http.Flusher).Flush()
+			w.Write(data[10:])
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:          source,
+			Dest:            &bytes.Buffer{},
+			ProgressHandler: cargo.ProgressHandlerWriter(&buf),
+		})
+
+		require.NoError(t, err)
+
+		assert.Regexp(t, `\r97\.7 KiB [0-9.]+ [KMGTPE]?i?B/s *\n$`, buf.String())
+		assert.NotContains(t, buf.String(), `%`)
+	})
+}
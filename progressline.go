@@ -0,0 +1,128 @@
+package cargo
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressLineInterval is the minimum time between two renders of the status
+// line written by ProgressHandlerWriter.
+const progressLineInterval = 100 * time.Millisecond
+
+// ProgressHandlerWriter provides a ProgressHandler that renders the progress as
+// a single status line, rewritten in place using a carriage return, such as
+// "1.2 MiB / 5.0 MiB (24%) 340 KiB/s ETA 11s". It is meant for a terminal,
+// like os.Stderr in a CLI tool.
+//
+// When the expected size is unknown only the bytes received and the speed are
+// shown. The line is rendered at most 10 times a second, and once more with a
+// trailing newline when the download completes. Errors writing to w are
+// ignored.
+func ProgressHandlerWriter(w io.Writer) ProgressHandler {
+	return &progressLineWriter{w: w}
+}
+
+type progressLineWriter struct {
+	w io.Writer
+
+	mu       sync.Mutex
+	expected int64
+	received int64
+	started  time.Time
+	rendered time.Time
+	width    int
+	done     bool
+}
+
+func (p *progressLineWriter) Expected(i int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expected = i
+	p.received = 0
+	p.started = time.Now()
+	p.rendered = time.Time{}
+	p.done = false
+}
+
+func (p *progressLineWriter) Receive(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.received += int64(i)
+	if time.Since(p.rendered) < progressLineInterval {
+		return
+	}
+	p.render("")
+}
+
+// Total renders the final line, ending it with a newline.
+func (p *progressLineWriter) Total(i int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done {
+		return
+	}
+	p.done = true
+
+	p.received = i
+	p.render("\n")
+}
+
+// render writes the status line, padded to cover a longer line written before
+// it. The caller must hold the mutex.
+func (p *progressLineWriter) render(end string) {
+	elapsed := time.Since(p.started)
+
+	var rate int64
+	if elapsed > 0 {
+		rate = int64(float64(p.received) / elapsed.Seconds())
+	}
+
+	var line string
+	if p.expected < 0 {
+		line = fmt.Sprintf("%s %s/s", formatBytes(p.received), formatBytes(rate))
+	} else {
+		pct := 100
+		if p.expected > 0 && p.received < p.expected {
+			pct = int(p.received * 100 / p.expected)
+		}
+		line = fmt.Sprintf("%s / %s (%d%%) %s/s", formatBytes(p.received), formatBytes(p.expected), pct, formatBytes(rate))
+
+		if remaining := p.expected - p.received; remaining > 0 && rate > 0 {
+			eta := time.Duration(float64(remaining) / float64(rate) * float64(time.Second))
+			line += " ETA " + eta.Round(time.Second).String()
+		}
+	}
+
+	padding := ""
+	if n := p.width - len(line); n > 0 {
+		padding = strings.Repeat(" ", n)
+	}
+	p.width = len(line)
+	p.rendered = time.Now()
+
+	io.WriteString(p.w, "\r"+line+padding+end)
+}
+
+// formatBytes formats a number of bytes using binary units, such as "512 B" or
+// "1.2 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value := float64(n) / unit
+	units := "KMGTPE"
+	i := 0
+	for value >= unit && i < len(units)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, units[i])
+}
@@ -0,0 +1,330 @@
+package cargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RangeSpec is a range of a file's bytes, and the writer it is downloaded to.
+type RangeSpec struct {
+	Start int64 // The offset of the first byte
+	End   int64 // The offset of the last byte, inclusive
+	Dest  io.Writer
+}
+
+func (r RangeSpec) length() int64 {
+	return r.End - r.Start + 1
+}
+
+// ErrInvalidRange is the error returned by DownloadRanges when a RangeSpec has
+// a negative Start, or an End before its Start.
+var ErrInvalidRange = errors.New(`invalid byte range`)
+
+// DownloadRanges downloads several ranges of the source's bytes, writing each
+// one to its own Dest, for example to fetch the non-contiguous segments of a
+// video.
+//
+// The ranges are requested together, and the parts of the server's
+// multipart/byteranges response are routed to their writers. Any range that
+// isn't in the response exactly as requested, including every range when the
+// server doesn't support multiple ranges, is then requested on its own, one at
+// a time. If the server ignores ranges altogether and sends the whole file,
+// every range is read from that one response, in offset order, and only a
+// range overlapping an earlier one is requested again. Each response is
+// passed to ValidateResponse before any of it is read.
+//
+// The data is written directly to each Dest, without being staged, so a failed
+// download may leave a range partially written. Each request is retried as
+// described for RetryPolicy, until data has been written. The options that
+// inspect the file as a whole, like the checksums, Sink, and Concurrency, are
+// ignored. The DownloadOutput's FileSize is the combined size of the ranges,
+// and its BytesReceived also counts the rest of the response bodies they were
+// read from, like the data before a range sent by a server ignoring ranges.
+func DownloadRanges(ctx context.Context, source *url.URL, ranges []RangeSpec, opts ...Option) (out *DownloadOutput, err error) {
+	in := DownloadInput{Source: source}
+	for _, opt := range opts {
+		opt(&in)
+	}
+
+	in, err = in.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	defer in.releaseClient()
+
+	var total int64
+	for _, r := range ranges {
+		if r.Start < 0 || r.End < r.Start {
+			return nil, fmt.Errorf("%w: %d-%d", ErrInvalidRange, r.Start, r.End)
+		}
+		if r.Dest == nil {
+			return nil, ErrMissingDest
+		}
+		total += r.length()
+	}
+
+	ctx, cancel := withDeadline(ctx, in)
+	defer cancel()
+
+	startTime := time.Now()
+
+	var (
+		resp      *http.Response
+		attempts  int
+		received  int64
+		delivered = make([]bool, len(ranges))
+	)
+	defer func() {
+		observeDownload(in, startTime, attempts, out, err)
+	}()
+
+	if in.ProgressHandler != nil {
+		in.ProgressHandler.Expected(total)
+	}
+	progress := createProgressWriter(in.ProgressHandler)
+
+	// Only the bytes read by the attempt that succeeds for each request are
+	// counted.
+	readCounted := func(read func(ctx context.Context) (int64, *http.Response, error)) func(ctx context.Context) (int64, *http.Response, error) {
+		return func(ctx context.Context) (int64, *http.Response, error) {
+			attempts++
+
+			ctx, wire := withWireBytes(ctx)
+			n, resp, err := read(ctx)
+			if err == nil {
+				received += wire.count(n)
+			}
+			return n, resp, err
+		}
+	}
+
+	if len(ranges) > 1 {
+		_, resp, err = readWithRetry(ctx, in, readCounted(func(ctx context.Context) (int64, *http.Response, error) {
+			return readMultipartRanges(ctx, in, ranges, delivered, progress)
+		}), nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, r := range ranges {
+		if delivered[i] {
+			continue
+		}
+
+		_, resp, err = readWithRetry(ctx, in, readCounted(func(ctx context.Context) (int64, *http.Response, error) {
+			return readRange(ctx, in, r, progress)
+		}), nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	completeProgress(in.ProgressHandler, total)
+
+	out = &DownloadOutput{
+		FileSize:      total,
+		Duration:      time.Since(startTime),
+		BytesReceived: received,
+		BytesWritten:  total,
+		Attempts:      attempts,
+	}
+	out.setResponse(resp)
+
+	return out, nil
+}
+
+// readMultipartRanges requests every range at once, and copies each part of a
+// multipart/byteranges response that matches a range into its Dest, marking
+// it as delivered. Any other response is left for the ranges to be requested
+// one at a time. A response that is read is validated first.
+func readMultipartRanges(ctx context.Context, in DownloadInput, ranges []RangeSpec, delivered []bool, progress io.Writer) (int64, *http.Response, error) {
+	in.enterPhase(PhaseConnecting)
+
+	req, err := newRequest(ctx, in)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	specs := make([]string, len(ranges))
+	for i, r := range ranges {
+		specs[i] = fmt.Sprintf("%d-%d", r.Start, r.End)
+	}
+	req.Header.Set("Range", "bytes="+strings.Join(specs, ","))
+
+	resp, err := sendRequest(in, req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	in.enterPhase(PhaseResponse)
+
+	readCtx, readCancel := withTimeout(ctx, in.clock, in.ReadTimeout)
+	defer readCancel()
+
+	received := &countingWriter{w: withMetricsBytes(in, io.Discard)}
+	defer func() { recordWireBytes(ctx, received.n) }()
+
+	body := io.TeeReader(detectTruncation(resp, 0), withRateLimit(readCtx, in, received))
+
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	multipartResp := resp.StatusCode == http.StatusPartialContent && mediaType == "multipart/byteranges"
+	if resp.StatusCode != http.StatusOK && !multipartResp {
+		return 0, resp, nil
+	}
+
+	if err := validateResponse(in, resp); err != nil {
+		return 0, resp, err
+	}
+
+	in.enterPhase(PhaseDownloading)
+
+	if resp.StatusCode == http.StatusOK {
+		n, err := readRangesFromFile(readCtx, ranges, delivered, body, progress)
+		return n, resp, err
+	}
+
+	parts := multipart.NewReader(body, params["boundary"])
+
+	var n int64
+	for {
+		part, err := parts.NextPart()
+		if errors.Is(err, io.EOF) {
+			return n, resp, nil
+		}
+		if err != nil {
+			return n, resp, err
+		}
+
+		start, end, _, ok := parseContentRange(part.Header.Get("Content-Range"))
+		if !ok {
+			continue
+		}
+
+		for i, r := range ranges {
+			if delivered[i] || r.Start != start || r.End != end {
+				continue
+			}
+
+			written, err := copyWithContext(readCtx, r.Dest, io.TeeReader(part, progress))
+			n += written
+			if err != nil {
+				return n, resp, err
+			}
+			if written != r.length() {
+				return n, resp, &TruncatedError{Received: written, Expected: r.length()}
+			}
+
+			delivered[i] = true
+			break
+		}
+	}
+}
+
+// readRangesFromFile copies each range into its Dest from the body of a
+// response holding the whole file, marking it as delivered. The body can only
+// be read forward, so the ranges are read in offset order, and a range
+// overlapping an earlier one is left to be requested on its own.
+func readRangesFromFile(ctx context.Context, ranges []RangeSpec, delivered []bool, body io.Reader, progress io.Writer) (int64, error) {
+	order := make([]int, len(ranges))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return ranges[order[a]].Start < ranges[order[b]].Start
+	})
+
+	var pos, n int64
+	for _, i := range order {
+		r := ranges[i]
+		if delivered[i] || r.Start < pos {
+			continue
+		}
+
+		skipped, err := copyWithContext(ctx, io.Discard, io.LimitReader(body, r.Start-pos))
+		pos += skipped
+		if err != nil {
+			return n, err
+		}
+
+		written, err := copyWithContext(ctx, r.Dest, io.TeeReader(io.LimitReader(body, r.length()), progress))
+		pos += written
+		n += written
+		if err != nil {
+			return n, err
+		}
+		if written != r.length() {
+			return n, &TruncatedError{Received: written, Expected: r.length()}
+		}
+
+		delivered[i] = true
+	}
+
+	return n, nil
+}
+
+// readRange requests a single range, and copies it into its Dest.
+func readRange(ctx context.Context, in DownloadInput, r RangeSpec, progress io.Writer) (int64, *http.Response, error) {
+	in.enterPhase(PhaseConnecting)
+
+	req, err := newRequest(ctx, in)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.Start, r.End))
+
+	resp, err := sendRequest(in, req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	in.enterPhase(PhaseResponse)
+
+	readCtx, readCancel := withTimeout(ctx, in.clock, in.ReadTimeout)
+	defer readCancel()
+
+	received := &countingWriter{w: withMetricsBytes(in, io.Discard)}
+	defer func() { recordWireBytes(ctx, received.n) }()
+
+	body := io.TeeReader(detectTruncation(resp, 0), withRateLimit(readCtx, in, received))
+
+	if err := validateResponse(in, resp); err != nil {
+		return 0, resp, err
+	}
+
+	if resp.StatusCode == http.StatusPartialContent {
+		start, end, _, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != r.Start || end < r.End {
+			return 0, resp, &ChunkAssemblyError{
+				Offset: r.Start,
+				Reason: fmt.Sprintf("invalid Content-Range %q", resp.Header.Get("Content-Range")),
+			}
+		}
+	} else {
+		// The server ignored the range and sent the whole file, so the data
+		// before the range is skipped.
+		if _, err := copyWithContext(readCtx, io.Discard, io.LimitReader(body, r.Start)); err != nil {
+			return 0, resp, err
+		}
+	}
+
+	in.enterPhase(PhaseDownloading)
+
+	n, err := copyWithContext(readCtx, r.Dest, io.TeeReader(io.LimitReader(body, r.length()), progress))
+	if err == nil && n != r.length() {
+		err = &TruncatedError{Received: n, Expected: r.length()}
+	}
+
+	return n, resp, err
+}
@@ -0,0 +1,136 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadRanges(t *testing.T) {
+	data := []byte(`0123456789abcdefghijklmnopqrstuvwxyz`)
+
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get(`Range`))
+
+		switch r.URL.Path {
+		case `/single`:
+			// Only single ranges are supported, so only the first is sent.
+			if rng := r.Header.Get(`Range`); strings.Contains(rng, `,`) {
+				r.Header.Set(`Range`, rng[:strings.Index(rng, `,`)])
+			}
+		case `/none`:
+			w.Write(data)
+			return
+		}
+		http.ServeContent(w, r, `data`, time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	download := func(path string) ([]string, *cargo.DownloadOutput, error) {
+		requests = nil
+		source, _ := url.Parse(server.URL + path)
+
+		bufs := make([]bytes.Buffer, 3)
+		out, err := cargo.DownloadRanges(context.Background(), source, []cargo.RangeSpec{
+			{Start: 0, End: 3, Dest: &bufs[0]},
+			{Start: 10, End: 15, Dest: &bufs[1]},
+			{Start: 30, End: 35, Dest: &bufs[2]},
+		})
+
+		return []string{bufs[0].String(), bufs[1].String(), bufs[2].String()}, out, err
+	}
+
+	t.Run(`given a server supporting multiple ranges`, func(t *testing.T) {
+		parts, out, err := download(`/`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{`0123`, `abcdef`, `uvwxyz`}, parts)
+		assert.Equal(t, int64(16), out.FileSize)
+		assert.Equal(t, []string{`bytes=0-3,10-15,30-35`}, requests)
+	})
+
+	t.Run(`given a server supporting single ranges`, func(t *testing.T) {
+		parts, out, err := download(`/single`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{`0123`, `abcdef`, `uvwxyz`}, parts)
+		assert.Equal(t, 4, out.Attempts)
+		assert.Equal(t, []string{`bytes=0-3,10-15,30-35`, `bytes=0-3`, `bytes=10-15`, `bytes=30-35`}, requests)
+	})
+
+	t.Run(`given a server ignoring ranges`, func(t *testing.T) {
+		parts, out, err := download(`/none`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{`0123`, `abcdef`, `uvwxyz`}, parts)
+		assert.Equal(t, 1, out.Attempts)
+		assert.Equal(t, []string{`bytes=0-3,10-15,30-35`}, requests)
+	})
+
+	t.Run(`given a server ignoring overlapping ranges`, func(t *testing.T) {
+		requests = nil
+		source, _ := url.Parse(server.URL + `/none`)
+
+		bufs := make([]bytes.Buffer, 3)
+		_, err := cargo.DownloadRanges(context.Background(), source, []cargo.RangeSpec{
+			{Start: 10, End: 15, Dest: &bufs[0]},
+			{Start: 0, End: 3, Dest: &bufs[1]},
+			{Start: 12, End: 17, Dest: &bufs[2]},
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{`abcdef`, `0123`, `cdefgh`}, []string{bufs[0].String(), bufs[1].String(), bufs[2].String()})
+		assert.Equal(t, []string{`bytes=10-15,0-3,12-17`, `bytes=12-17`}, requests)
+	})
+
+	t.Run(`given a response validator`, func(t *testing.T) {
+		rejected := errors.New(`rejected`)
+
+		for _, path := range []string{`/`, `/single`, `/none`} {
+			t.Run(path, func(t *testing.T) {
+				source, _ := url.Parse(server.URL + path)
+
+				var statuses []int
+				var buf bytes.Buffer
+				_, err := cargo.DownloadRanges(context.Background(), source, []cargo.RangeSpec{
+					{Start: 0, End: 3, Dest: &buf},
+					{Start: 10, End: 15, Dest: &buf},
+				}, func(in *cargo.DownloadInput) {
+					in.ValidateResponse = func(r *http.Response) error {
+						statuses = append(statuses, r.StatusCode)
+						return rejected
+					}
+				})
+
+				assert.ErrorIs(t, err, rejected)
+				assert.Len(t, statuses, 1)
+				assert.Equal(t, 0, buf.Len())
+			})
+		}
+	})
+
+	t.Run(`given an invalid range`, func(t *testing.T) {
+		source, _ := url.Parse(server.URL)
+
+		_, err := cargo.DownloadRanges(context.Background(), source, []cargo.RangeSpec{
+			{Start: 10, End: 5, Dest: &bytes.Buffer{}},
+		})
+
+		assert.True(t, errors.Is(err, cargo.ErrInvalidRange))
+	})
+}
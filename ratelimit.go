@@ -0,0 +1,97 @@
+package cargo
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting the combined read rate of every
+// download it is given to, through DownloadInput.RateLimit. It is safe for
+// concurrent use. Waiters are served in the order they arrive, so a download
+// can't be starved by others sharing the limiter.
+type RateLimiter struct {
+	rate  float64 // Bytes per second
+	burst float64
+
+	clock  clock
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter allowing bytesPerSecond bytes to be read per
+// second, with bursts of up to one second's worth of data.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	rate := math.Max(float64(bytesPerSecond), 1)
+
+	return &RateLimiter{
+		rate:   rate,
+		burst:  rate,
+		tokens: rate,
+		clock:  realClock{},
+		last:   time.Now(),
+	}
+}
+
+// WaitN blocks until n bytes may be read, or the context is done. Each call
+// reserves its bytes immediately, and later calls wait behind it, which keeps
+// the limiter fair across concurrent downloads.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.clock.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	timer := l.clock.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		// Return the reservation, so waiters behind it aren't held up by bytes
+		// that will never be read.
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+// rateLimitWriter waits on the limiter for every write. It is used as a tee of
+// the response body, so the read is paced by the limiter.
+type rateLimitWriter struct {
+	ctx     context.Context
+	limiter *RateLimiter
+}
+
+func (w *rateLimitWriter) Write(b []byte) (int, error) {
+	if err := w.limiter.WaitN(w.ctx, len(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// withRateLimit adds the input's RateLimit to the writer the response body is
+// tee'd to, if one is set.
+func withRateLimit(ctx context.Context, in DownloadInput, w io.Writer) io.Writer {
+	if in.RateLimit == nil {
+		return w
+	}
+	return io.MultiWriter(w, &rateLimitWriter{ctx: ctx, limiter: in.RateLimit})
+}
@@ -0,0 +1,62 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Run(`given downloads sharing a limiter`, func(t *testing.T) {
+		data := bytes.Repeat([]byte(`0123456789`), 7500)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		// Two 75KB downloads share a 100KB/s limiter. The first 100KB is the
+		// burst, so the remaining 50KB takes at least half a second.
+		limiter := cargo.NewRateLimiter(100000)
+
+		start := time.Now()
+
+		results := cargo.DownloadBatch(context.Background(), []cargo.DownloadInput{
+			{Source: source, Dest: &bytes.Buffer{}, RateLimit: limiter},
+			{Source: source, Dest: &bytes.Buffer{}, RateLimit: limiter},
+		}, cargo.BatchOptions{Concurrency: 2})
+
+		for _, result := range results {
+			require.NoError(t, result.Err)
+		}
+
+		assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	})
+
+	t.Run(`given a canceled wait`, func(t *testing.T) {
+		limiter := cargo.NewRateLimiter(1000)
+
+		require.NoError(t, limiter.WaitN(context.Background(), 1000))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		assert.ErrorIs(t, limiter.WaitN(ctx, 1000), context.DeadlineExceeded)
+
+		// The canceled reservation is returned, so the next wait only covers its
+		// own bytes.
+		start := time.Now()
+		require.NoError(t, limiter.WaitN(context.Background(), 100))
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+}
@@ -0,0 +1,100 @@
+package cargo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// TooManyRedirectsError is the error returned when a request follows more
+// redirects than DownloadInput.MaxRedirects allows.
+type TooManyRedirectsError struct {
+	Max int      // The maximum number of redirects allowed
+	URL *url.URL // The redirect target that exceeded the limit
+}
+
+func (e *TooManyRedirectsError) Error() string {
+	return fmt.Sprintf("stopped after %d redirects at %s", e.Max, e.URL)
+}
+
+// limitRedirects returns a copy of the client that fails once a request has
+// followed more than max redirects. The client's own CheckRedirect function, if
+// it has one, is still called for redirects within the limit.
+func limitRedirects(client *http.Client, max int) *http.Client {
+	limited := *client
+	limited.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > max {
+			return &TooManyRedirectsError{Max: max, URL: req.URL}
+		}
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(req, via)
+		}
+		return nil
+	}
+	return &limited
+}
+
+// preserveAuthOnRedirect returns a copy of the client that sends the original
+// request's Authorization header with every redirect, including to another
+// host, where the client would otherwise remove it. The client's own
+// CheckRedirect function, if it has one, is still called, and otherwise the
+// default limit of 10 redirects applies.
+func preserveAuthOnRedirect(client *http.Client) *http.Client {
+	preserving := *client
+	preserving.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if client.CheckRedirect != nil {
+			if err := client.CheckRedirect(req, via); err != nil {
+				return err
+			}
+		} else if len(via) >= 10 {
+			return errors.New(`stopped after 10 redirects`)
+		}
+
+		if auth := via[0].Header.Get("Authorization"); auth != "" && req.Header.Get("Authorization") == "" {
+			req.Header.Set("Authorization", auth)
+		}
+		return nil
+	}
+	return &preserving
+}
+
+// setResponse records the response's status code and cache status, along with
+// the final URL, the canonical URL, the remote address, and the redirects
+// followed to receive it.
+func (o *DownloadOutput) setResponse(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+
+	o.StatusCode = resp.StatusCode
+	o.CacheStatus = parseCacheStatus(resp.Header)
+	if resp.Request == nil {
+		return
+	}
+
+	o.URL = resp.Request.URL
+	o.CanonicalURL = canonicalURL(resp)
+	o.RemoteAddr = responseRemoteAddr(resp)
+	o.RedirectChain = []*url.URL{resp.Request.URL}
+	for req := resp.Request; req.Response != nil; req = req.Response.Request {
+		o.Redirects++
+		o.RedirectChain = append([]*url.URL{req.Response.Request.URL}, o.RedirectChain...)
+	}
+}
+
+// canonicalURL resolves the response's Content-Location header, which may be
+// relative, against the URL of the request. It returns nil if the header is
+// missing or invalid.
+func canonicalURL(resp *http.Response) *url.URL {
+	location := resp.Header.Get("Content-Location")
+	if location == "" {
+		return nil
+	}
+
+	ref, err := url.Parse(location)
+	if err != nil {
+		return nil
+	}
+	return resp.Request.URL.ResolveReference(ref)
+}
@@ -0,0 +1,176 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadMaxRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hops, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, `/r/`))
+		if hops > 0 {
+			http.Redirect(w, r, fmt.Sprintf(`/r/%d`, hops-1), http.StatusFound)
+			return
+		}
+		w.Write([]byte(`Hello World`))
+	}))
+	defer server.Close()
+
+	download := func(path string, max int) (*cargo.DownloadOutput, error) {
+		source, _ := url.Parse(server.URL + path)
+
+		return cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:       source,
+			Dest:         &bytes.Buffer{},
+			MaxRedirects: max,
+		})
+	}
+
+	t.Run(`given redirects within the limit`, func(t *testing.T) {
+		out, err := download(`/r/3`, 3)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, out.Redirects)
+		assert.Equal(t, `/r/0`, out.URL.Path)
+
+		var chain []string
+		for _, u := range out.RedirectChain {
+			chain = append(chain, u.Path)
+		}
+		assert.Equal(t, []string{`/r/3`, `/r/2`, `/r/1`, `/r/0`}, chain)
+	})
+
+	t.Run(`given redirects over the limit`, func(t *testing.T) {
+		_, err := download(`/r/4`, 3)
+
+		var redirectErr *cargo.TooManyRedirectsError
+		require.True(t, errors.As(err, &redirectErr))
+
+		assert.Equal(t, 3, redirectErr.Max)
+		assert.Equal(t, `/r/0`, redirectErr.URL.Path)
+	})
+
+	t.Run(`given no limit`, func(t *testing.T) {
+		out, err := download(`/r/2`, 0)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, out.Redirects)
+		assert.Len(t, out.RedirectChain, 3)
+	})
+
+	t.Run(`given no redirects`, func(t *testing.T) {
+		out, err := download(`/r/0`, 0)
+
+		require.NoError(t, err)
+
+		require.Len(t, out.RedirectChain, 1)
+		assert.Equal(t, out.URL, out.RedirectChain[0])
+	})
+}
+
+func TestDownloadCanonicalURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if location := r.URL.Query().Get(`location`); location != "" {
+			w.Header().Set(`Content-Location`, location)
+		}
+		w.Write([]byte(`Hello World`))
+	}))
+	defer server.Close()
+
+	download := func(location string) *cargo.DownloadOutput {
+		source, _ := url.Parse(server.URL + `/files/alias`)
+		if location != "" {
+			source.RawQuery = url.Values{`location`: {location}}.Encode()
+		}
+
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   &bytes.Buffer{},
+		})
+		require.NoError(t, err)
+		return out
+	}
+
+	t.Run(`given no header`, func(t *testing.T) {
+		assert.Nil(t, download(``).CanonicalURL)
+	})
+
+	t.Run(`given an absolute header`, func(t *testing.T) {
+		out := download(`https://cdn.example.com/files/v1.tar.gz`)
+
+		require.NotNil(t, out.CanonicalURL)
+		assert.Equal(t, `https://cdn.example.com/files/v1.tar.gz`, out.CanonicalURL.String())
+	})
+
+	t.Run(`given a relative header`, func(t *testing.T) {
+		out := download(`v1.tar.gz`)
+
+		require.NotNil(t, out.CanonicalURL)
+		assert.Equal(t, server.URL+`/files/v1.tar.gz`, out.CanonicalURL.String())
+	})
+
+	t.Run(`given a root relative header`, func(t *testing.T) {
+		out := download(`/archive/v1.tar.gz`)
+
+		require.NotNil(t, out.CanonicalURL)
+		assert.Equal(t, server.URL+`/archive/v1.tar.gz`, out.CanonicalURL.String())
+	})
+}
+
+func TestDownloadPreserveAuthOnRedirect(t *testing.T) {
+	var auth string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get(`Authorization`)
+		w.Write([]byte(`Hello World`))
+	}))
+	defer target.Close()
+
+	// The redirect uses another host name for the same address, so the client
+	// treats it as a different host.
+	targetURL := strings.Replace(target.URL, `127.0.0.1`, `localhost`, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, targetURL+`/signed`, http.StatusFound)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	download := func(preserve bool) {
+		auth = ``
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:                 source,
+			Dest:                   &bytes.Buffer{},
+			BearerToken:            `secret`,
+			PreserveAuthOnRedirect: preserve,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, out.Redirects)
+	}
+
+	t.Run(`given the default`, func(t *testing.T) {
+		download(false)
+
+		assert.Empty(t, auth)
+	})
+
+	t.Run(`given PreserveAuthOnRedirect`, func(t *testing.T) {
+		download(true)
+
+		assert.Equal(t, `Bearer secret`, auth)
+	})
+}
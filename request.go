@@ -0,0 +1,32 @@
+package cargo
+
+import (
+	"net/http"
+)
+
+// BasicAuth contains the credentials used for HTTP basic authentication.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// applyRequestOptions sets the headers configured on the input on a request
+// that was created by DownloadInput.CreateRequest.
+func applyRequestOptions(req *http.Request, in DownloadInput) {
+	for key, values := range in.Header {
+		req.Header.Del(key)
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	if in.BasicAuth != nil {
+		req.SetBasicAuth(in.BasicAuth.Username, in.BasicAuth.Password)
+	}
+	if in.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+in.BearerToken)
+	}
+	if in.Expect100Continue {
+		req.Header.Set("Expect", "100-continue")
+	}
+}
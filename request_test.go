@@ -0,0 +1,187 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get(`Authorization`) + `|` + r.Header.Get(`X-Custom`)))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	download := func(t *testing.T, in cargo.DownloadInput) string {
+		var buf bytes.Buffer
+
+		in.Source = source
+		in.Dest = &buf
+
+		_, err := cargo.Download(context.Background(), in)
+
+		require.NoError(t, err)
+
+		return buf.String()
+	}
+
+	t.Run(`given basic auth`, func(t *testing.T) {
+		body := download(t, cargo.DownloadInput{
+			BasicAuth: &cargo.BasicAuth{Username: `user`, Password: `pass`},
+			Header:    http.Header{`X-Custom`: []string{`value`}},
+		})
+
+		assert.Equal(t, `Basic dXNlcjpwYXNz|value`, body)
+	})
+
+	t.Run(`given a bearer token with a custom request`, func(t *testing.T) {
+		body := download(t, cargo.DownloadInput{
+			BearerToken: `token`,
+			CreateRequest: func(ctx context.Context, u *url.URL) (*http.Request, error) {
+				return http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+			},
+		})
+
+		assert.Equal(t, `Bearer token|`, body)
+	})
+
+	t.Run(`given a netrc file`, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), `.netrc`)
+		err := os.WriteFile(path, []byte("machine example.com login other password other\nmachine "+source.Hostname()+" login user password pass\n"), 0600)
+		require.NoError(t, err)
+
+		body := download(t, cargo.DownloadInput{
+			UseNetrc:  true,
+			NetrcPath: path,
+		})
+
+		assert.Equal(t, `Basic dXNlcjpwYXNz|`, body)
+	})
+
+	t.Run(`given a netrc file without a matching entry`, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), `.netrc`)
+		err := os.WriteFile(path, []byte("machine example.com login other password other\n"), 0600)
+		require.NoError(t, err)
+
+		body := download(t, cargo.DownloadInput{
+			UseNetrc:  true,
+			NetrcPath: path,
+		})
+
+		assert.Equal(t, `|`, body)
+	})
+}
+
+func TestDownloadHooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(`X-Injected`) == `` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	var status int
+
+	_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source: source,
+		Dest:   &bytes.Buffer{},
+		OnRequest: func(r *http.Request) {
+			r.Header.Set(`X-Injected`, `true`)
+		},
+		OnResponse: func(r *http.Response) {
+			status = r.StatusCode
+		},
+		ValidateResponse: cargo.ValidateStatusCodeEqual(http.StatusOK),
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusTeapot, status)
+}
+
+func TestDownloadExpect100Continue(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Reading the body makes the server send the 100 Continue response.
+		query, _ := io.ReadAll(r.Body)
+		w.Write(query)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	var continued int32
+
+	var buf bytes.Buffer
+	_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source:            source,
+		Dest:              &buf,
+		Expect100Continue: true,
+		CreateRequest: func(ctx context.Context, u *url.URL) (*http.Request, error) {
+			ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+				Got100Continue: func() {
+					atomic.StoreInt32(&continued, 1)
+				},
+			})
+			return http.NewRequestWithContext(ctx, http.MethodGet, u.String(), strings.NewReader(`{"query":"all"}`))
+		},
+	})
+
+	require.NoError(t, err)
+
+	assert.Equal(t, `{"query":"all"}`, buf.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&continued))
+}
+
+func TestDownloadMethodBody(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Method+` `+string(query))
+
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`result for ` + string(query)))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	var buf bytes.Buffer
+	_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source: source,
+		Dest:   &buf,
+		Method: http.MethodPost,
+		Body: func() io.Reader {
+			return strings.NewReader(`{"query":"all"}`)
+		},
+		ValidateResponse: cargo.ValidateStatusCodeEqual(http.StatusOK),
+		Retry:            &cargo.RetryPolicy{MaxAttempts: 2, Delay: time.Millisecond, RetryNonIdempotent: true},
+		Concurrency:      4,
+	})
+
+	require.NoError(t, err)
+
+	assert.Equal(t, `result for {"query":"all"}`, buf.String())
+	assert.Equal(t, []string{`POST {"query":"all"}`, `POST {"query":"all"}`}, requests)
+}
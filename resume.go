@@ -0,0 +1,596 @@
+package cargo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidState is the error returned when a DownloadState is missing its
+// Source or Path.
+var ErrInvalidState = errors.New(`invalid download state`)
+
+// DownloadState records the progress of a resumable download, so that it can be
+// continued by ResumeFromState after an interruption, including from a
+// different process.
+type DownloadState struct {
+	// Source is the URL being downloaded.
+	Source *url.URL
+
+	// ETag and LastModified are the validators from the response that started
+	// the download. They are used to verify that the remote file hasn't changed
+	// before the download is continued.
+	ETag         string
+	LastModified string
+
+	// Size is the number of bytes that have been downloaded to Path.
+	Size int64
+
+	// Path is the file that the downloaded data is staged in until the download
+	// is complete.
+	Path string
+}
+
+type downloadStateJSON struct {
+	Source       string `json:"source"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Size         int64  `json:"size"`
+	Path         string `json:"path"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s DownloadState) MarshalJSON() ([]byte, error) {
+	var source string
+	if s.Source != nil {
+		source = s.Source.String()
+	}
+
+	return json.Marshal(downloadStateJSON{
+		Source:       source,
+		ETag:         s.ETag,
+		LastModified: s.LastModified,
+		Size:         s.Size,
+		Path:         s.Path,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *DownloadState) UnmarshalJSON(data []byte) error {
+	var v downloadStateJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	source, err := url.Parse(v.Source)
+	if err != nil {
+		return err
+	}
+
+	*s = DownloadState{
+		Source:       source,
+		ETag:         v.ETag,
+		LastModified: v.LastModified,
+		Size:         v.Size,
+		Path:         v.Path,
+	}
+
+	return nil
+}
+
+// SaveState writes the state as JSON to the file at path. The file is replaced
+// atomically, so an interruption never leaves a partially written state.
+func SaveState(path string, state *DownloadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// LoadState reads a state written by SaveState.
+func LoadState(path string) (*DownloadState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var state DownloadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// ResumeFromState downloads the state's Source, continuing from the data that
+// has already been staged at the state's Path. A new download is started by
+// passing a state with only Source and Path set.
+//
+// The staged data is continued with a Range request that carries the state's
+// validators in an If-Range header (the ETag, or Last-Modified if there is no
+// strong ETag), so the server only sends the remainder if the file is
+// unchanged. If the file changed the server sends the whole file instead, the
+// staged data is discarded, and the download restarts from the beginning. This
+// is reported in DownloadOutput.Restarted.
+//
+// The state is updated as the download progresses. If the download fails the
+// staged file is kept, so the state can be saved with SaveState and passed to
+// ResumeFromState again later. Once the download is complete the staged data is
+// copied to the input's Dest, and the staged file is removed.
+//
+// The input's Source, Sink, Cache, IfModifiedSince, StagingFactory, DryRun,
+// and ResumeFrom are ignored. With Decompress, the staged file holds the body
+// as it was received, and it is decompressed as it's copied to Dest.
+// ValidateResponse is only called for responses that aren't a continuation of
+// the staged data (i.e. not a 206 Partial Content response).
+func ResumeFromState(ctx context.Context, state *DownloadState, in DownloadInput) (out *DownloadOutput, err error) {
+	if state == nil || state.Source == nil || state.Path == "" {
+		return nil, ErrInvalidState
+	}
+
+	in.Source = state.Source
+	in.Sink = nil
+	in.Cache = nil
+	in.IfModifiedSince = time.Time{}
+	in.DryRun = false
+	in.ResumeFrom = nil
+
+	// The staged offsets are in the compressed bytes, so the body is staged as
+	// it was received, and decompressed once the download is complete.
+	decompress := in.Decompress
+	in.Decompress = false
+
+	in, err = in.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	defer in.releaseClient()
+	if in.Dest == nil {
+		return nil, ErrMissingDest
+	}
+
+	ctx, cancel := withDeadline(ctx, in)
+	defer cancel()
+
+	startTime := time.Now()
+
+	var (
+		destWritten int64
+		attempts    int
+	)
+	defer func() {
+		if err != nil {
+			err = onFailure(in, err, destWritten)
+		}
+		observeDownload(in, startTime, attempts, out, err)
+	}()
+
+	destClosed := false
+	defer func() {
+		if !destClosed {
+			closeDest(in)
+		}
+	}()
+
+	staged, err := os.OpenFile(state.Path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer staged.Close()
+
+	var (
+		restarted bool
+		received  int64
+	)
+
+	lastRead, resp, err := readWithRetry(ctx, in, func(ctx context.Context) (int64, *http.Response, error) {
+		attempts++
+		ctx, wire := withWireBytes(ctx)
+		n, resp, err := resumeAttempt(ctx, in, state, staged, &restarted)
+		received += wire.count(n)
+		return n, resp, checkDiskFull(err, state.Path)
+	}, func() error { return nil })
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMinBytes(in, state.Size); err != nil {
+		return nil, err
+	}
+
+	// The final attempt continued from staged data if it read less than the
+	// whole file.
+	resumed := state.Size > lastRead
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	in.enterPhase(PhaseVerifying)
+
+	var decoder Decoder
+	if decompress {
+		decoder = responseDecoder(resp)
+	}
+
+	if err := hashStaged(ctx, in, stagedReader(staged, decoder)); err != nil {
+		return nil, err
+	}
+	if decompress {
+		if err := checkStagedDecompressedSize(ctx, in, stagedReader(staged, decoder)); err != nil {
+			return nil, err
+		}
+	}
+	if err := verifyChecksum(in); err != nil {
+		return nil, err
+	}
+
+	if err := prepareStaged(in, &callerFileStaging{staged}); err != nil {
+		return nil, err
+	}
+
+	in.enterPhase(PhaseFinalizing)
+
+	finalSize, written, err := copyToDest(ctx, in, stagedReader(staged, decoder))
+	destWritten = written
+	if err != nil {
+		return nil, err
+	}
+
+	destClosed = true
+	if err := closeDest(in); err != nil {
+		return nil, err
+	}
+
+	if in.AfterCopy != nil {
+		in.AfterCopy(staged)
+	}
+
+	staged.Close()
+	os.Remove(state.Path)
+
+	out = &DownloadOutput{
+		FileSize:      finalSize,
+		Duration:      time.Since(startTime),
+		Restarted:     restarted,
+		BytesReceived: received,
+		BytesWritten:  written,
+		Attempts:      attempts,
+		Resumed:       resumed,
+	}
+	out.setResponse(resp)
+	out.setChecksums(in)
+
+	return out, nil
+}
+
+// RangeNotSatisfiableAction is what a resumed download does when the server
+// responds to the request for the remainder with 416 Range Not Satisfiable.
+type RangeNotSatisfiableAction int
+
+const (
+	// RangeNotSatisfiableRestart discards the staged data, and downloads the
+	// file again from the beginning.
+	RangeNotSatisfiableRestart RangeNotSatisfiableAction = iota
+
+	// RangeNotSatisfiableComplete treats the staged data as the complete file
+	// when its size matches the total reported by the response, and otherwise
+	// restarts, for example when the remote file shrank.
+	RangeNotSatisfiableComplete
+)
+
+// alreadyComplete reports if the response to the request for the remainder
+// from offset shows that the staged data is already the complete file, for the
+// input's ResumeRangeNotSatisfiable action. When it is, the staged data is
+// reported to the ProgressHandler as the whole download.
+func (in DownloadInput) alreadyComplete(resp *http.Response, offset int64) bool {
+	if offset <= 0 || resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || in.ResumeRangeNotSatisfiable != RangeNotSatisfiableComplete {
+		return false
+	}
+	if _, _, total, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || total != offset {
+		return false
+	}
+
+	if in.ProgressHandler != nil {
+		in.ProgressHandler.Expected(offset)
+		in.ProgressHandler.Receive(int(offset))
+	}
+	completeProgress(in.ProgressHandler, offset)
+
+	return true
+}
+
+// resumeAttempt performs a single attempt of a resumable download, appending
+// the response body to the staged file. If the staged data has to be discarded
+// restarted is set to true.
+func resumeAttempt(ctx context.Context, in DownloadInput, state *DownloadState, staged *os.File, restarted *bool) (int64, *http.Response, error) {
+	offset, err := staged.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, nil, err
+	}
+	if offset > 0 && state.ifRange() == "" {
+		// Without validators there's no way to know the staged data is still valid.
+		if offset, err = truncateStaged(staged); err != nil {
+			return 0, nil, err
+		}
+		*restarted = true
+	}
+	state.Size = offset
+
+	for {
+		in.enterPhase(PhaseConnecting)
+
+		req, err := newRequest(ctx, in)
+		if err != nil {
+			return 0, nil, err
+		}
+		start := in.overlapStart(offset)
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+			req.Header.Set("If-Range", state.ifRange())
+		}
+
+		if err := ctx.Err(); err != nil {
+			return 0, nil, err
+		}
+
+		resp, err := sendRequest(in, req)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		in.enterPhase(PhaseResponse)
+
+		if in.alreadyComplete(resp, offset) {
+			resp.Body.Close()
+			return 0, resp, nil
+		}
+
+		if offset > 0 && !state.continuesWith(resp, start) {
+			if offset, err = truncateStaged(staged); err != nil {
+				resp.Body.Close()
+				return 0, nil, err
+			}
+			state.Size = 0
+			*restarted = true
+
+			// A server that honors If-Range sends the whole file when it has
+			// changed, so the body can be used as is. Otherwise the file is
+			// requested again.
+			if resp.StatusCode != http.StatusOK {
+				resp.Body.Close()
+				continue
+			}
+		}
+
+		if start < offset {
+			match, err := verifyOverlap(resp, staged, start, offset)
+			if err != nil {
+				resp.Body.Close()
+				return 0, resp, err
+			}
+			if !match {
+				if offset, err = truncateStaged(staged); err != nil {
+					resp.Body.Close()
+					return 0, nil, err
+				}
+				state.Size = 0
+				*restarted = true
+
+				resp.Body.Close()
+				continue
+			}
+		}
+
+		in.enterPhase(PhaseDownloading)
+
+		n, err := readResumeBody(ctx, in, state, staged, resp, offset)
+		resp.Body.Close()
+
+		return n, resp, err
+	}
+}
+
+func readResumeBody(ctx context.Context, in DownloadInput, state *DownloadState, staged *os.File, resp *http.Response, offset int64) (int64, error) {
+	if offset == 0 {
+		if err := validateResponse(in, resp); err != nil {
+			return 0, err
+		}
+
+		state.ETag = resp.Header.Get("ETag")
+		state.LastModified = resp.Header.Get("Last-Modified")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if in.ProgressHandler != nil {
+		in.ProgressHandler.Expected(in.resumedSize(resp, in.overlapStart(offset)))
+		if offset > 0 {
+			in.ProgressHandler.Receive(int(offset))
+		}
+	}
+
+	if offset > 0 {
+		// The sniff and BOM only apply to the start of the file.
+		in.RejectHTMLSniff = false
+		in.StripBOM = false
+	}
+
+	return readBody(ctx, in, resp, &stateWriter{w: staged, state: state}, offset)
+}
+
+// overlapStart returns the offset a resumed download requests its remainder
+// from, which includes the input's ResumeVerifyOverlap before the offset.
+func (in DownloadInput) overlapStart(offset int64) int64 {
+	if in.ResumeVerifyOverlap <= 0 || offset <= 0 {
+		return offset
+	}
+	if in.ResumeVerifyOverlap >= offset {
+		return 0
+	}
+	return offset - in.ResumeVerifyOverlap
+}
+
+// resumedSize returns the size of the file from the response to the request
+// for its remainder from start, which includes any overlap downloaded again.
+// The complete length from a partial response's Content-Range is the size of
+// the file, while its body's length counts from start, so the overlap is
+// counted once.
+func (in DownloadInput) resumedSize(resp *http.Response, start int64) int64 {
+	if in.ExpectedSize > 0 {
+		return in.ExpectedSize
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return in.ContentLength(resp)
+	}
+	if total := contentRangeTotal(resp.Header.Get("Content-Range")); total >= 0 {
+		return total
+	}
+
+	size := in.ContentLength(resp)
+	if size >= 0 {
+		size += start
+	}
+	return size
+}
+
+// verifyOverlap reads the bytes from start to offset, which were downloaded
+// again, from the response body, and reports if they match the staged data.
+// The staged data is left positioned at the offset, and the response's
+// ContentLength no longer counts the overlap, so the rest of the body is read
+// as if the response started at the offset.
+func verifyOverlap(resp *http.Response, staged io.ReadSeeker, start, offset int64) (bool, error) {
+	size := offset - start
+
+	received := make([]byte, size)
+	if _, err := io.ReadFull(resp.Body, received); err != nil {
+		return false, err
+	}
+	if resp.ContentLength >= 0 {
+		resp.ContentLength -= size
+	}
+
+	existing := make([]byte, size)
+	if _, err := staged.Seek(start, io.SeekStart); err != nil {
+		return false, err
+	}
+	if _, err := io.ReadFull(staged, existing); err != nil {
+		return false, err
+	}
+	if _, err := staged.Seek(offset, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(received, existing), nil
+}
+
+// ifRange returns the value for the If-Range header, or an empty string if the
+// state has no usable validator. Weak ETags can't be used with If-Range.
+func (s *DownloadState) ifRange() string {
+	if s.ETag != "" && !strings.HasPrefix(s.ETag, `W/`) {
+		return s.ETag
+	}
+	return s.LastModified
+}
+
+// continuesWith reports if the response is a continuation of the staged data
+// from offset, for a remote file that hasn't changed.
+func (s *DownloadState) continuesWith(resp *http.Response, offset int64) bool {
+	if resp.StatusCode != http.StatusPartialContent {
+		return false
+	}
+	if start, _, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || start != offset {
+		return false
+	}
+	if s.ETag != "" && s.ifRange() == s.ETag {
+		return resp.Header.Get("ETag") == s.ETag
+	}
+	return resp.Header.Get("Last-Modified") == s.LastModified
+}
+
+func truncateStaged(f *os.File) (int64, error) {
+	if err := f.Truncate(0); err != nil {
+		return 0, err
+	}
+	return f.Seek(0, io.SeekStart)
+}
+
+// stateWriter updates the state's Size for every write.
+type stateWriter struct {
+	w     io.Writer
+	state *DownloadState
+}
+
+func (w *stateWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.state.Size += int64(n)
+	return n, err
+}
+
+// parseContentRange parses a Content-Range header value in the form
+// "bytes 0-99/1000". The start and end are -1 for an unsatisfied range
+// ("bytes */1000"), and the total is -1 when the complete length is unknown
+// ("bytes 0-99/*").
+func parseContentRange(value string) (start, end, total int64, ok bool) {
+	if !strings.HasPrefix(value, `bytes `) {
+		return 0, 0, 0, false
+	}
+	value = strings.TrimPrefix(value, `bytes `)
+
+	idx := strings.IndexByte(value, '/')
+	if idx == -1 {
+		return 0, 0, 0, false
+	}
+	rangeValue, totalValue := value[:idx], value[idx+1:]
+
+	total = -1
+	if totalValue != `*` {
+		var err error
+		if total, err = strconv.ParseInt(totalValue, 10, 64); err != nil {
+			return 0, 0, 0, false
+		}
+	}
+
+	if rangeValue == `*` {
+		return -1, -1, total, true
+	}
+
+	idx = strings.IndexByte(rangeValue, '-')
+	if idx == -1 {
+		return 0, 0, 0, false
+	}
+	start, startErr := strconv.ParseInt(rangeValue[:idx], 10, 64)
+	end, endErr := strconv.ParseInt(rangeValue[idx+1:], 10, 64)
+	if startErr != nil || endErr != nil || end < start {
+		return 0, 0, 0, false
+	}
+
+	return start, end, total, true
+}
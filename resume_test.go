@@ -0,0 +1,532 @@
+package cargo_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumeFromState(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 10000)
+
+	var interrupt int32
+	var ranges []string
+	var ignoreIfRange bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get(`Range`))
+		if ignoreIfRange {
+			r.Header.Del(`If-Range`)
+		}
+
+		w.Header().Set(`ETag`, `"v1"`)
+
+		if atomic.CompareAndSwapInt32(&interrupt, 1, 0) {
+			w.Header().Set(`Content-Length`, `100000`)
+			w.Write(data[:40000])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+
+		http.ServeContent(w, r, ``, time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given an interrupted download`, func(t *testing.T) {
+		ranges = nil
+		atomic.StoreInt32(&interrupt, 1)
+
+		dir := t.TempDir()
+		state := &cargo.DownloadState{Source: source, Path: filepath.Join(dir, `partial`)}
+
+		var buf bytes.Buffer
+
+		_, err := cargo.ResumeFromState(context.Background(), state, cargo.DownloadInput{Dest: &buf})
+
+		require.Error(t, err)
+
+		assert.Equal(t, int64(40000), state.Size)
+		assert.Equal(t, `"v1"`, state.ETag)
+
+		require.NoError(t, cargo.SaveState(filepath.Join(dir, `state.json`), state))
+
+		loaded, err := cargo.LoadState(filepath.Join(dir, `state.json`))
+
+		require.NoError(t, err)
+		require.Equal(t, state, loaded)
+
+		out, err := cargo.ResumeFromState(context.Background(), loaded, cargo.DownloadInput{
+			Dest:             &buf,
+			ValidateResponse: cargo.ValidateStatusCodeEqual(http.StatusOK),
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{``, `bytes=40000-`}, ranges)
+		assert.Equal(t, int64(len(data)), out.FileSize)
+		assert.False(t, out.Restarted)
+		assert.True(t, out.Resumed)
+		assert.Equal(t, 1, out.Attempts)
+		assert.Equal(t, http.StatusPartialContent, out.StatusCode)
+		assert.Equal(t, data, buf.Bytes())
+
+		_, err = os.Stat(state.Path)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run(`given a remote that changed`, func(t *testing.T) {
+		ranges = nil
+
+		path := filepath.Join(t.TempDir(), `partial`)
+		require.NoError(t, os.WriteFile(path, []byte(`stale data`), 0600))
+
+		state := &cargo.DownloadState{Source: source, Path: path, ETag: `"v0"`, Size: 10}
+
+		var buf bytes.Buffer
+
+		out, err := cargo.ResumeFromState(context.Background(), state, cargo.DownloadInput{Dest: &buf})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{`bytes=10-`}, ranges)
+		assert.True(t, out.Restarted)
+		assert.False(t, out.Resumed)
+		assert.Equal(t, data, buf.Bytes())
+	})
+
+	t.Run(`given a remote that changed and a server that ignores If-Range`, func(t *testing.T) {
+		ranges = nil
+		ignoreIfRange = true
+		defer func() { ignoreIfRange = false }()
+
+		path := filepath.Join(t.TempDir(), `partial`)
+		require.NoError(t, os.WriteFile(path, []byte(`stale data`), 0600))
+
+		state := &cargo.DownloadState{Source: source, Path: path, ETag: `"v0"`, Size: 10}
+
+		var buf bytes.Buffer
+
+		out, err := cargo.ResumeFromState(context.Background(), state, cargo.DownloadInput{Dest: &buf})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{`bytes=10-`, ``}, ranges)
+		assert.True(t, out.Restarted)
+		assert.Equal(t, data, buf.Bytes())
+	})
+}
+
+func TestDownloadResumeFrom(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 1000)
+	sum := sha256.Sum256(data)
+
+	t.Run(`given a server that supports ranges`, func(t *testing.T) {
+		var ranges []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ranges = append(ranges, r.Header.Get(`Range`))
+			http.ServeContent(w, r, `data.bin`, time.Time{}, bytes.NewReader(data))
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:           source,
+			Dest:             &buf,
+			ResumeFrom:       bytes.NewReader(data[:300]),
+			ExpectedChecksum: sum[:],
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+		assert.Equal(t, []string{`bytes=300-`}, ranges)
+		assert.True(t, out.Resumed)
+		assert.False(t, out.Restarted)
+	})
+
+	t.Run(`given a server without range support`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:           source,
+			Dest:             &buf,
+			ResumeFrom:       bytes.NewReader([]byte(`stale data`)),
+			ExpectedChecksum: sum[:],
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+		assert.False(t, out.Resumed)
+		assert.True(t, out.Restarted)
+	})
+
+	t.Run(`given a remainder without a Content-Length`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(`Content-Range`, fmt.Sprintf(`bytes 300-%d/%d`, len(data)-1, len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			// Flushing before the body is written sends it chunked.
+			w.(http.Flusher).Flush()
+			w.Write(data[300:])
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		var expected int64
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:     source,
+			Dest:       &buf,
+			ResumeFrom: bytes.NewReader(data[:300]),
+			MaxBytes:   int64(len(data)),
+			ProgressHandler: cargo.ProgressHandlerFunc(func(e, _ int64) {
+				expected = e
+			}),
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+		assert.Equal(t, int64(len(data)), expected)
+		assert.True(t, out.Resumed)
+	})
+}
+
+func TestResumeVerifyOverlap(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 1000)
+	sum := sha256.Sum256(data)
+
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get(`Range`))
+		w.Header().Set(`ETag`, `"v1"`)
+		http.ServeContent(w, r, `data.bin`, time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	corrupt := append([]byte{}, data[:300]...)
+	copy(corrupt[296:], `XXXX`)
+
+	t.Run(`given a matching overlap`, func(t *testing.T) {
+		ranges = nil
+
+		var expected int64
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:              source,
+			Dest:                &buf,
+			ResumeFrom:          bytes.NewReader(data[:300]),
+			ResumeVerifyOverlap: 16,
+			ExpectedChecksum:    sum[:],
+			MaxBytes:            int64(len(data)),
+			ProgressHandler: cargo.ProgressHandlerFunc(func(ex, _ int64) {
+				expected = ex
+			}),
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+		assert.Equal(t, []string{`bytes=284-`}, ranges)
+		assert.True(t, out.Resumed)
+		assert.False(t, out.Restarted)
+		assert.Equal(t, int64(len(data)), expected)
+		assert.Equal(t, int64(len(data)), out.FileSize)
+	})
+
+	t.Run(`given a matching overlap in a staged state`, func(t *testing.T) {
+		ranges = nil
+
+		path := filepath.Join(t.TempDir(), `partial`)
+		require.NoError(t, os.WriteFile(path, data[:300], 0600))
+
+		state := &cargo.DownloadState{Source: source, Path: path, ETag: `"v1"`, Size: 300}
+
+		var expected int64
+		var buf bytes.Buffer
+		out, err := cargo.ResumeFromState(context.Background(), state, cargo.DownloadInput{
+			Dest:                &buf,
+			ResumeVerifyOverlap: 16,
+			ProgressHandler: cargo.ProgressHandlerFunc(func(ex, _ int64) {
+				expected = ex
+			}),
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+		assert.Equal(t, []string{`bytes=284-`}, ranges)
+		assert.True(t, out.Resumed)
+		assert.Equal(t, int64(len(data)), expected)
+	})
+
+	t.Run(`given a mismatched overlap`, func(t *testing.T) {
+		ranges = nil
+
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:              source,
+			Dest:                &buf,
+			ResumeFrom:          bytes.NewReader(corrupt),
+			ResumeVerifyOverlap: 16,
+			ExpectedChecksum:    sum[:],
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+		assert.Equal(t, []string{`bytes=284-`, ``}, ranges)
+		assert.False(t, out.Resumed)
+		assert.True(t, out.Restarted)
+	})
+
+	t.Run(`given a mismatched overlap in a staged state`, func(t *testing.T) {
+		ranges = nil
+
+		path := filepath.Join(t.TempDir(), `partial`)
+		require.NoError(t, os.WriteFile(path, corrupt, 0600))
+
+		state := &cargo.DownloadState{Source: source, Path: path, ETag: `"v1"`, Size: 300}
+
+		var buf bytes.Buffer
+		out, err := cargo.ResumeFromState(context.Background(), state, cargo.DownloadInput{
+			Dest:                &buf,
+			ResumeVerifyOverlap: 16,
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+		assert.Equal(t, []string{`bytes=284-`, ``}, ranges)
+		assert.True(t, out.Restarted)
+	})
+}
+
+func TestResumeDecompress(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 10000)
+	sum := sha256.Sum256(data)
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(data)
+	zw.Close()
+
+	offset := compressed.Len() / 2
+
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get(`Range`))
+
+		w.Header().Set(`ETag`, `"v1-gzip"`)
+		w.Header().Set(`Content-Encoding`, `gzip`)
+		http.ServeContent(w, r, ``, time.Time{}, bytes.NewReader(compressed.Bytes()))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+	header := http.Header{`Accept-Encoding`: []string{`gzip`}}
+
+	t.Run(`given ResumeFrom with the compressed data`, func(t *testing.T) {
+		ranges = nil
+
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:           source,
+			Dest:             &buf,
+			Header:           header,
+			Decompress:       true,
+			ResumeFrom:       bytes.NewReader(compressed.Bytes()[:offset]),
+			ExpectedChecksum: sum[:],
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{fmt.Sprintf(`bytes=%d-`, offset)}, ranges)
+		assert.True(t, out.Resumed)
+		assert.Equal(t, int64(len(data)), out.FileSize)
+		assert.Equal(t, data, buf.Bytes())
+	})
+
+	t.Run(`given a staged file with the compressed data`, func(t *testing.T) {
+		ranges = nil
+
+		path := filepath.Join(t.TempDir(), `partial`)
+		require.NoError(t, os.WriteFile(path, compressed.Bytes()[:offset], 0600))
+
+		state := &cargo.DownloadState{Source: source, Path: path, ETag: `"v1-gzip"`, Size: int64(offset)}
+
+		var buf bytes.Buffer
+		out, err := cargo.ResumeFromState(context.Background(), state, cargo.DownloadInput{
+			Dest:             &buf,
+			Header:           header,
+			Decompress:       true,
+			ExpectedChecksum: sum[:],
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{fmt.Sprintf(`bytes=%d-`, offset)}, ranges)
+		assert.True(t, out.Resumed)
+		assert.Equal(t, int64(len(data)), out.FileSize)
+		assert.Equal(t, data, buf.Bytes())
+	})
+
+	t.Run(`given ResumeFrom with a different decompressed size`, func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:                 source,
+			Dest:                   &buf,
+			Header:                 header,
+			Decompress:             true,
+			ResumeFrom:             bytes.NewReader(compressed.Bytes()[:offset]),
+			ExpectDecompressedSize: int64(len(data)) - 1,
+		})
+
+		var sizeErr *cargo.DecompressedSizeError
+		require.ErrorAs(t, err, &sizeErr)
+		assert.Equal(t, int64(len(data)), sizeErr.Actual)
+		assert.Zero(t, buf.Len())
+	})
+
+	t.Run(`given a staged file without Decompress`, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), `partial`)
+		require.NoError(t, os.WriteFile(path, compressed.Bytes()[:offset], 0600))
+
+		state := &cargo.DownloadState{Source: source, Path: path, ETag: `"v1-gzip"`, Size: int64(offset)}
+
+		var buf bytes.Buffer
+		_, err := cargo.ResumeFromState(context.Background(), state, cargo.DownloadInput{
+			Dest:   &buf,
+			Header: header,
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, compressed.Bytes(), buf.Bytes())
+	})
+}
+
+func TestResumeRangeNotSatisfiable(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 1000)
+	sum := sha256.Sum256(data)
+
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get(`Range`))
+
+		w.Header().Set(`ETag`, `"v1"`)
+		http.ServeContent(w, r, ``, time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	resume := func(t *testing.T, staged []byte, action cargo.RangeNotSatisfiableAction) (*cargo.DownloadOutput, []byte) {
+		ranges = nil
+
+		path := filepath.Join(t.TempDir(), `partial`)
+		require.NoError(t, os.WriteFile(path, staged, 0600))
+
+		state := &cargo.DownloadState{Source: source, Path: path, ETag: `"v1"`, Size: int64(len(staged))}
+
+		var buf bytes.Buffer
+		out, err := cargo.ResumeFromState(context.Background(), state, cargo.DownloadInput{
+			Dest:                      &buf,
+			ExpectedChecksum:          sum[:],
+			ResumeRangeNotSatisfiable: action,
+		})
+		require.NoError(t, err)
+
+		return out, buf.Bytes()
+	}
+
+	t.Run(`given a complete file`, func(t *testing.T) {
+		out, written := resume(t, data, cargo.RangeNotSatisfiableComplete)
+
+		assert.Equal(t, []string{fmt.Sprintf(`bytes=%d-`, len(data))}, ranges)
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, out.StatusCode)
+		assert.True(t, out.Resumed)
+		assert.False(t, out.Restarted)
+		assert.Equal(t, data, written)
+	})
+
+	t.Run(`given a remote that shrank`, func(t *testing.T) {
+		out, written := resume(t, append(append([]byte{}, data...), `extra`...), cargo.RangeNotSatisfiableComplete)
+
+		assert.Equal(t, []string{fmt.Sprintf(`bytes=%d-`, len(data)+5), ``}, ranges)
+		assert.True(t, out.Restarted)
+		assert.Equal(t, data, written)
+	})
+
+	t.Run(`given a complete file and the default action`, func(t *testing.T) {
+		out, written := resume(t, data, cargo.RangeNotSatisfiableRestart)
+
+		assert.Equal(t, []string{fmt.Sprintf(`bytes=%d-`, len(data)), ``}, ranges)
+		assert.True(t, out.Restarted)
+		assert.Equal(t, data, written)
+	})
+
+	t.Run(`given ResumeFrom with a complete file`, func(t *testing.T) {
+		ranges = nil
+
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:                    source,
+			Dest:                      &buf,
+			ResumeFrom:                bytes.NewReader(data),
+			ExpectedChecksum:          sum[:],
+			ResumeRangeNotSatisfiable: cargo.RangeNotSatisfiableComplete,
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{fmt.Sprintf(`bytes=%d-`, len(data))}, ranges)
+		assert.True(t, out.Resumed)
+		assert.Equal(t, data, buf.Bytes())
+	})
+
+	t.Run(`given ResumeFrom with a remote that shrank`, func(t *testing.T) {
+		ranges = nil
+
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:           source,
+			Dest:             &buf,
+			ResumeFrom:       bytes.NewReader(append(append([]byte{}, data...), `extra`...)),
+			ExpectedChecksum: sum[:],
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{fmt.Sprintf(`bytes=%d-`, len(data)+5), ``}, ranges)
+		assert.True(t, out.Restarted)
+		assert.Equal(t, data, buf.Bytes())
+	})
+}
@@ -0,0 +1,144 @@
+package cargo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// resumeFromAttempt performs a single attempt of a download that continues the
+// data read from DownloadInput.ResumeFrom, which has already been staged. The
+// remainder is requested starting at the offset, less the input's
+// ResumeVerifyOverlap. If the server sends the whole file instead, the
+// overlap doesn't match the staged data, or the range isn't satisfiable,
+// restart is called to discard the staged data, and the file is read from the
+// start.
+func resumeFromAttempt(ctx context.Context, in DownloadInput, stage *Staging, offset *int64, restart func() error) (int64, *http.Response, error) {
+	in.enterPhase(PhaseConnecting)
+
+	req, err := newRequest(ctx, in)
+	if err != nil {
+		return 0, nil, err
+	}
+	start := in.overlapStart(*offset)
+	if *offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	resp, err := sendRequest(in, req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	in.enterPhase(PhaseResponse)
+
+	if in.conditional() && resp.StatusCode == http.StatusNotModified {
+		return 0, resp, ErrNotModified
+	}
+
+	if in.alreadyComplete(resp, *offset) {
+		return 0, resp, checkMinBytes(in, *offset)
+	}
+
+	if *offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		if err := restart(); err != nil {
+			return 0, resp, err
+		}
+		*offset = 0
+		resp.Body.Close()
+
+		return resumeFromAttempt(ctx, in, stage, offset, restart)
+	}
+
+	if *offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		if rangeStart, _, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || rangeStart != start {
+			return 0, resp, &ChunkAssemblyError{
+				Offset: start,
+				Reason: fmt.Sprintf("invalid Content-Range %q for the remainder", resp.Header.Get("Content-Range")),
+			}
+		}
+
+		if start < *offset {
+			match, err := verifyOverlap(resp, *stage, start, *offset)
+			if err != nil {
+				return 0, resp, err
+			}
+			if !match {
+				if err := restart(); err != nil {
+					return 0, resp, err
+				}
+				*offset = 0
+				resp.Body.Close()
+
+				return resumeFromAttempt(ctx, in, stage, offset, restart)
+			}
+		}
+	} else {
+		if err := validateResponse(in, resp); err != nil {
+			return 0, resp, err
+		}
+		if *offset > 0 {
+			if err := restart(); err != nil {
+				return 0, resp, err
+			}
+			*offset = 0
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, resp, err
+	}
+
+	contentLen := in.resumedSize(resp, in.overlapStart(*offset))
+	if err := checkMaxBytes(in, contentLen); err != nil {
+		return 0, resp, err
+	}
+	if in.ProgressHandler != nil {
+		in.ProgressHandler.Expected(contentLen)
+		if *offset > 0 {
+			in.ProgressHandler.Receive(int(*offset))
+		}
+	}
+
+	if *offset > 0 {
+		// The sniff and BOM only apply to the start of the file.
+		in.RejectHTMLSniff = false
+		in.StripBOM = false
+	}
+
+	in.enterPhase(PhaseDownloading)
+
+	n, err := readBody(ctx, in, resp, stagingDest(stage), *offset)
+	if err == nil {
+		err = checkMinBytes(in, *offset+n)
+	}
+
+	return n, resp, err
+}
+
+// hashStaged writes the staged data through the input's ChecksumWriter and
+// Hashers, for downloads whose data wasn't all read in a single pass.
+func hashStaged(ctx context.Context, in DownloadInput, staged io.ReadSeeker) error {
+	hashes := in.hashes()
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	in.resetHashes()
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	writers := make([]io.Writer, len(hashes))
+	for i, h := range hashes {
+		writers[i] = h
+	}
+	_, err := copyWithContext(ctx, io.MultiWriter(writers...), staged)
+	return err
+}
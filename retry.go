@@ -0,0 +1,342 @@
+package cargo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrAttemptTimeout is the error returned for an attempt that exceeded the
+// DownloadInput.AttemptTimeout, while the download's context was still valid.
+var ErrAttemptTimeout = errors.New(`download attempt timed out`)
+
+// RetryPolicy controls how a download is retried after a failed attempt. An
+// attempt covers creating the request, sending it, and reading the body to the
+// temporary destination. The final copy into Dest is never retried.
+type RetryPolicy struct {
+	// The maximum number of attempts, including the first one. Values less than
+	// 1 are treated as 1.
+	MaxAttempts int
+
+	// The delay before the first retry. Each following retry doubles the
+	// previous delay. If there is no delay specified a value of 1 second will be
+	// used.
+	//
+	// The actual wait is chosen at random between zero and the computed delay
+	// ("full jitter"), so downloads retrying against the same server after an
+	// outage don't all retry at once.
+	Delay time.Duration
+
+	// Optional function returning the delay before the given retry, starting
+	// from 1 for the first retry. When set it replaces Delay and the jitter.
+	Backoff func(retry int) time.Duration
+
+	// Optional function used to decide if a failed attempt should be retried. It
+	// receives the attempt's response, which will be nil if no response was
+	// received, and the error. By default every error is retried except an
+	// HTTPResponseError with a 4xx status code other than 429, a
+	// TooManyRedirectsError, a DiskFullError, an ExceededLimitError, a
+	// ContentLengthMismatchError, and a DecompressedSizeError.
+	ShouldRetry func(*http.Response, error) bool
+
+	// Optional function used in place of ShouldRetry for content-aware
+	// decisions, such as retrying an API that reports errors in the body of a
+	// 200 response. Along with the response and error it receives the first
+	// PeekSize bytes of the response body, which are buffered before the
+	// response is validated and then replayed, so the download still receives
+	// the whole body. The buffer costs an allocation of PeekSize bytes for each
+	// response. It is also called for a response that passed validation, with a
+	// nil error, and returning true fails the attempt with ErrRetryableResponse
+	// so it is retried. The peek is nil when no response was received, and for
+	// the ranged requests of parallel and resumed downloads.
+	ShouldRetryBody func(resp *http.Response, peek []byte, err error) bool
+
+	// Optional number of bytes passed to ShouldRetryBody. Defaults to 512.
+	PeekSize int
+
+	// Optional flag to allow retrying an attempt that sent a request with a
+	// method other than GET or HEAD, such as a POST from a custom CreateRequest.
+	// Those requests may have side effects, so by default they aren't retried.
+	// A request carrying a one-time token should also not be retried, which
+	// can't be detected, so it needs a ShouldRetry that returns false.
+	RetryNonIdempotent bool
+
+	// Optional flag to add a unique "_cb" query parameter to the URL of each
+	// retry, so caches between Cargo and the server are bypassed in case they
+	// served a stale or broken body. The first attempt uses the Source as is.
+	CacheBustOnRetry bool
+}
+
+// ErrRetryableResponse is the error for an attempt whose response passed
+// validation, but that RetryPolicy.ShouldRetryBody asked to retry.
+var ErrRetryableResponse = errors.New(`response rejected by ShouldRetryBody`)
+
+// RetryError is returned when every attempt allowed by the RetryPolicy has
+// failed. Err is the error from the last attempt.
+//
+// If the download's context is canceled or its deadline is exceeded while
+// attempts remain, the context's error is returned instead.
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("download failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+func defaultShouldRetry(_ *http.Response, err error) bool {
+	var respErr *HTTPResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode >= 500 || respErr.StatusCode == http.StatusTooManyRequests
+	}
+	var (
+		redirectErr *TooManyRedirectsError
+		diskErr     *DiskFullError
+		limitErr    *ExceededLimitError
+		lengthErr   *ContentLengthMismatchError
+		sizeErr     *DecompressedSizeError
+	)
+	return !errors.As(err, &redirectErr) && !errors.As(err, &diskErr) && !errors.As(err, &limitErr) &&
+		!errors.As(err, &lengthErr) && !errors.As(err, &sizeErr)
+}
+
+// attemptFunc performs a single attempt of a download, returning the number of
+// bytes read along with the response so failures can be classified.
+type attemptFunc func(context.Context) (int64, *http.Response, error)
+
+// readWithRetry runs attempts of the download until one succeeds or the retry
+// policy is exhausted. The reset function is called before each retry to
+// discard the data written by the previous attempt. When reset is nil the data
+// can't be discarded, so an attempt that wrote any data is never retried. The
+// response of the last attempt is returned, with its body already closed.
+func readWithRetry(ctx context.Context, in DownloadInput, attempt attemptFunc, reset func() error) (int64, *http.Response, error) {
+	policy := RetryPolicy{MaxAttempts: 1}
+	if in.Retry != nil {
+		policy = *in.Retry
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.Delay == 0 {
+		policy.Delay = 1 * time.Second
+	}
+	if policy.ShouldRetry == nil {
+		policy.ShouldRetry = defaultShouldRetry
+	}
+	if policy.PeekSize <= 0 {
+		policy.PeekSize = defaultPeekSize
+	}
+
+	backoff := policy.Backoff
+	if backoff == nil {
+		backoff = fullJitterBackoff(policy.Delay)
+	}
+
+	sent := &sentRequests{}
+	ctx = context.WithValue(ctx, sentRequestsKey{}, sent)
+
+	for count := 1; ; count++ {
+		attemptCtx := ctx
+		if count > 1 && policy.CacheBustOnRetry {
+			attemptCtx = context.WithValue(ctx, cacheBustKey{}, strconv.FormatInt(time.Now().UnixNano(), 10))
+		}
+
+		var peek *responsePeek
+		if policy.ShouldRetryBody != nil {
+			peek = &responsePeek{size: policy.PeekSize, fn: policy.ShouldRetryBody}
+			attemptCtx = context.WithValue(attemptCtx, responsePeekKey{}, peek)
+		}
+
+		n, resp, err := runAttempt(attemptCtx, in, attempt)
+		if err == nil {
+			return n, resp, nil
+		}
+		if progressErr := progressCanceled(in.ProgressHandler); progressErr != nil {
+			return n, resp, progressErr
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return n, resp, ctxErr
+		}
+		if sent.nonIdempotent() && !policy.RetryNonIdempotent {
+			return n, resp, err
+		}
+		if errors.Is(err, ErrNotModified) || (reset == nil && n > 0) || !shouldRetry(policy, peek, resp, err) {
+			return n, resp, err
+		}
+		if count >= policy.MaxAttempts {
+			if in.Retry == nil {
+				return n, resp, err
+			}
+			return n, resp, &RetryError{Attempts: count, Err: err}
+		}
+
+		select {
+		case <-ctx.Done():
+			return n, resp, ctx.Err()
+		case <-in.clock.After(backoff(count)):
+		}
+
+		if reset != nil {
+			if err := reset(); err != nil {
+				return 0, resp, err
+			}
+		}
+	}
+}
+
+// shouldRetry reports if the policy allows the failed attempt to be retried.
+func shouldRetry(policy RetryPolicy, peek *responsePeek, resp *http.Response, err error) bool {
+	if peek == nil {
+		return policy.ShouldRetry(resp, err)
+	}
+	if errors.Is(err, ErrRetryableResponse) {
+		return true
+	}
+	return policy.ShouldRetryBody(resp, peek.data, err)
+}
+
+// runAttempt runs the attempt, bounded by the input's AttemptTimeout.
+func runAttempt(ctx context.Context, in DownloadInput, attempt attemptFunc) (int64, *http.Response, error) {
+	if in.AttemptTimeout <= 0 {
+		return attempt(ctx)
+	}
+
+	attemptCtx, attemptCancel := withTimeout(ctx, in.clock, in.AttemptTimeout)
+	defer attemptCancel()
+
+	n, resp, err := attempt(attemptCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+		err = ErrAttemptTimeout
+	}
+	return n, resp, err
+}
+
+// fullJitterBackoff returns a backoff that doubles the base delay for each
+// retry, and picks a random delay between zero and that value. Each call uses
+// its own random source, so separate downloads don't retry in lockstep.
+func fullJitterBackoff(base time.Duration) func(int) time.Duration {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	return func(retry int) time.Duration {
+		delay := base
+		for i := 1; i < retry && delay < math.MaxInt64/2; i++ {
+			delay *= 2
+		}
+		return time.Duration(rng.Int63n(int64(delay) + 1))
+	}
+}
+
+// cacheBustKey is the context key for the cache busting value of a retry.
+type cacheBustKey struct{}
+
+// requestURL returns the URL requested for the source. During a retry with
+// RetryPolicy.CacheBustOnRetry it is a copy of the source with the "_cb" query
+// parameter set.
+func requestURL(ctx context.Context, source *url.URL) *url.URL {
+	value, ok := ctx.Value(cacheBustKey{}).(string)
+	if !ok {
+		return source
+	}
+
+	// The parameter is appended, so the existing query is kept as it is.
+	u := *source
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += "_cb=" + value
+	return &u
+}
+
+// sentRequestsKey is the context key for the sentRequests of a download.
+type sentRequestsKey struct{}
+
+// sentRequests records if any request sent by the attempts of a download used
+// a method that isn't idempotent. Parallel ranges send requests concurrently,
+// so it is updated atomically.
+type sentRequests struct {
+	unsafe int32 // atomic
+}
+
+func (s *sentRequests) nonIdempotent() bool {
+	return atomic.LoadInt32(&s.unsafe) != 0
+}
+
+// recordSentRequest notes the request's method in the sentRequests carried by
+// its context, if any.
+func recordSentRequest(req *http.Request) {
+	sent, ok := req.Context().Value(sentRequestsKey{}).(*sentRequests)
+	if !ok {
+		return
+	}
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead:
+	default:
+		atomic.StoreInt32(&sent.unsafe, 1)
+	}
+}
+
+// defaultPeekSize is the number of bytes passed to RetryPolicy.ShouldRetryBody
+// when no PeekSize is set.
+const defaultPeekSize = 512
+
+// responsePeekKey is the context key for the responsePeek of an attempt.
+type responsePeekKey struct{}
+
+// responsePeek holds the start of the response body of an attempt, for
+// RetryPolicy.ShouldRetryBody.
+type responsePeek struct {
+	size int
+	fn   func(*http.Response, []byte, error) bool
+	data []byte
+}
+
+// peekResponse buffers the start of the response body, when the context
+// carries a responsePeek, and replaces the body with one that replays it.
+func peekResponse(ctx context.Context, resp *http.Response) error {
+	peek, ok := ctx.Value(responsePeekKey{}).(*responsePeek)
+	if !ok {
+		return nil
+	}
+
+	buf := make([]byte, peek.size)
+	n, err := io.ReadFull(resp.Body, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	peek.data = buf[:n]
+
+	resp.Body = &replayedBody{
+		Reader: io.MultiReader(bytes.NewReader(peek.data), resp.Body),
+		Closer: resp.Body,
+	}
+	return nil
+}
+
+// acceptResponse returns ErrRetryableResponse if the validated response should
+// be retried according to the peeked body.
+func acceptResponse(ctx context.Context, resp *http.Response) error {
+	if peek, ok := ctx.Value(responsePeekKey{}).(*responsePeek); ok && peek.fn(resp, peek.data, nil) {
+		return ErrRetryableResponse
+	}
+	return nil
+}
+
+// replayedBody is a response body with its start replayed from a buffer.
+type replayedBody struct {
+	io.Reader
+	io.Closer
+}
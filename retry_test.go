@@ -0,0 +1,275 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadRetry(t *testing.T) {
+	t.Run(`given a server that recovers`, func(t *testing.T) {
+		var requests int32
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`hello`))
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:           source,
+			Dest:             &buf,
+			ValidateResponse: cargo.ValidateStatusCodeEqual(http.StatusOK),
+			Retry:            &cargo.RetryPolicy{MaxAttempts: 3, Delay: time.Millisecond},
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+		assert.Equal(t, 3, out.Attempts)
+		assert.Equal(t, http.StatusOK, out.StatusCode)
+		assert.Equal(t, int64(5), out.FileSize)
+		assert.Equal(t, `hello`, buf.String())
+	})
+
+	t.Run(`given a client error`, func(t *testing.T) {
+		var requests int32
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		source, _ := url.Parse(server.URL)
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:           source,
+			Dest:             &bytes.Buffer{},
+			ValidateResponse: cargo.ValidateStatusCodeEqual(http.StatusOK),
+			Retry:            &cargo.RetryPolicy{MaxAttempts: 3, Delay: time.Millisecond},
+		})
+
+		var respErr *cargo.HTTPResponseError
+		require.True(t, errors.As(err, &respErr))
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	})
+}
+
+func TestDownloadAttemptTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	t.Run(`given attempts that time out`, func(t *testing.T) {
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:         source,
+			Dest:           &bytes.Buffer{},
+			AttemptTimeout: 20 * time.Millisecond,
+			Retry:          &cargo.RetryPolicy{MaxAttempts: 2, Delay: time.Millisecond},
+		})
+
+		var retryErr *cargo.RetryError
+		require.True(t, errors.As(err, &retryErr))
+
+		assert.Equal(t, 2, retryErr.Attempts)
+		assert.ErrorIs(t, err, cargo.ErrAttemptTimeout)
+	})
+
+	t.Run(`given a total deadline shorter than the attempts`, func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := cargo.Download(ctx, cargo.DownloadInput{
+			Source:         source,
+			Dest:           &bytes.Buffer{},
+			AttemptTimeout: 20 * time.Millisecond,
+			Retry:          &cargo.RetryPolicy{MaxAttempts: 10, Delay: time.Millisecond},
+		})
+
+		var retryErr *cargo.RetryError
+		assert.False(t, errors.As(err, &retryErr))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run(`given a Deadline shorter than the attempts`, func(t *testing.T) {
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:         source,
+			Dest:           &bytes.Buffer{},
+			AttemptTimeout: 20 * time.Millisecond,
+			Retry:          &cargo.RetryPolicy{MaxAttempts: 10, Delay: time.Millisecond},
+			Deadline:       50 * time.Millisecond,
+		})
+
+		var retryErr *cargo.RetryError
+		assert.False(t, errors.As(err, &retryErr))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestDownloadRetryBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	var retries []int
+	_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source:           source,
+		Dest:             &bytes.Buffer{},
+		ValidateResponse: cargo.ValidateStatusCodeEqual(http.StatusOK),
+		Retry: &cargo.RetryPolicy{
+			MaxAttempts: 3,
+			Delay:       time.Hour,
+			Backoff: func(retry int) time.Duration {
+				retries = append(retries, retry)
+				return time.Millisecond
+			},
+		},
+	})
+
+	var retryErr *cargo.RetryError
+	require.True(t, errors.As(err, &retryErr))
+
+	assert.Equal(t, 3, retryErr.Attempts)
+	assert.Equal(t, []int{1, 2}, retries)
+}
+
+func TestDownloadRetryNonIdempotent(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	download := func(policy cargo.RetryPolicy) error {
+		atomic.StoreInt32(&requests, 0)
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source: source,
+			Dest:   &bytes.Buffer{},
+			CreateRequest: func(ctx context.Context, u *url.URL) (*http.Request, error) {
+				return http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+			},
+			ValidateResponse: cargo.ValidateStatusCodeEqual(http.StatusOK),
+			Retry:            &policy,
+		})
+		return err
+	}
+
+	t.Run(`given a POST request`, func(t *testing.T) {
+		err := download(cargo.RetryPolicy{MaxAttempts: 3, Delay: time.Millisecond})
+
+		var respErr *cargo.HTTPResponseError
+		require.True(t, errors.As(err, &respErr))
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	})
+
+	t.Run(`given a POST request with RetryNonIdempotent`, func(t *testing.T) {
+		err := download(cargo.RetryPolicy{MaxAttempts: 3, Delay: time.Millisecond, RetryNonIdempotent: true})
+
+		var retryErr *cargo.RetryError
+		require.True(t, errors.As(err, &retryErr))
+
+		assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	})
+}
+
+func TestDownloadCacheBustOnRetry(t *testing.T) {
+	var queries []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		if len(queries) < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`hello`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL + `?v=1`)
+
+	var buf bytes.Buffer
+	_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source:           source,
+		Dest:             &buf,
+		ValidateResponse: cargo.ValidateStatusCodeEqual(http.StatusOK),
+		Retry:            &cargo.RetryPolicy{MaxAttempts: 2, Delay: time.Millisecond, CacheBustOnRetry: true},
+	})
+
+	require.NoError(t, err)
+	require.Len(t, queries, 2)
+
+	assert.Equal(t, `v=1`, queries[0])
+	assert.Regexp(t, `^v=1&_cb=[0-9]+$`, queries[1])
+	assert.Equal(t, `hello`, buf.String())
+}
+
+func TestDownloadRetryShouldRetryBody(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.Write([]byte(`{"error":"try again"}`))
+			return
+		}
+		w.Write([]byte(`{"data":"hello"}`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	var peeks []string
+
+	var buf bytes.Buffer
+	out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+		Source: source,
+		Dest:   &buf,
+		Retry: &cargo.RetryPolicy{
+			MaxAttempts: 3,
+			Delay:       time.Millisecond,
+			PeekSize:    8,
+			ShouldRetryBody: func(resp *http.Response, peek []byte, err error) bool {
+				peeks = append(peeks, string(peek))
+				return err != nil || bytes.HasPrefix(peek, []byte(`{"error"`))
+			},
+		},
+	})
+
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, out.Attempts)
+	assert.Equal(t, []string{`{"error"`, `{"error"`, `{"data":`}, peeks)
+	assert.Equal(t, `{"data":"hello"}`, buf.String())
+}
@@ -0,0 +1,65 @@
+package cargo
+
+import "sync"
+
+// bufferedSink delivers the data written to it to a Sink on a separate
+// goroutine, through a channel holding a bounded number of chunks. Once the
+// sink fails, writes return its error.
+type bufferedSink struct {
+	fn     func([]byte) error
+	n      *int64
+	chunks chan []byte
+	done   chan struct{}
+	err    error
+
+	closeOnce sync.Once
+}
+
+// newBufferedSink starts delivering chunks to fn, buffering up to size of
+// them. The number of bytes fn accepts is added to n, which may only be read
+// once Close has returned.
+func newBufferedSink(fn func([]byte) error, size int, n *int64) *bufferedSink {
+	s := &bufferedSink{
+		fn:     fn,
+		n:      n,
+		chunks: make(chan []byte, size),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *bufferedSink) run() {
+	defer close(s.done)
+
+	for chunk := range s.chunks {
+		if err := s.fn(chunk); err != nil {
+			s.err = err
+			return
+		}
+		*s.n += int64(len(chunk))
+	}
+}
+
+func (s *bufferedSink) Write(b []byte) (int, error) {
+	chunk := make([]byte, len(b))
+	copy(chunk, b)
+
+	select {
+	case s.chunks <- chunk:
+		return len(b), nil
+	case <-s.done:
+		return 0, s.err
+	}
+}
+
+// Close waits for the buffered chunks to be delivered, and returns the sink's
+// error, if it failed. Chunks that are still buffered after a failure are
+// dropped. It is safe to call more than once.
+func (s *bufferedSink) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.chunks)
+	})
+	<-s.done
+	return s.err
+}
@@ -0,0 +1,90 @@
+package cargo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// sniffLen is the number of bytes considered by http.DetectContentType.
+const sniffLen = 512
+
+// HTMLResponseError is the error returned when DownloadInput.RejectHTMLSniff is
+// set and the start of the response body is detected as an HTML document.
+type HTMLResponseError struct {
+	ContentType string // The detected content type, e.g. "text/html; charset=utf-8"
+}
+
+func (e *HTMLResponseError) Error() string {
+	return fmt.Sprintf("unexpected html response (%s)", e.ContentType)
+}
+
+// rejectHTML reads the start of the body and returns an HTMLResponseError if it
+// is detected as HTML. Otherwise a reader is returned that replays the sniffed
+// bytes before the rest of the body.
+func rejectHTML(body io.Reader) (io.Reader, error) {
+	buf := make([]byte, sniffLen)
+
+	n, err := io.ReadFull(body, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	buf = buf[:n]
+
+	if contentType := http.DetectContentType(buf); strings.HasPrefix(contentType, `text/html`) {
+		return nil, &HTMLResponseError{ContentType: contentType}
+	}
+
+	return io.MultiReader(bytes.NewReader(buf), body), nil
+}
+
+// htmlRejecter runs rejectHTML on the first call to Read, so the body is
+// sniffed as part of the read.
+type htmlRejecter struct {
+	r       io.Reader
+	checked bool
+}
+
+func (h *htmlRejecter) Read(p []byte) (int, error) {
+	if !h.checked {
+		h.checked = true
+
+		r, err := rejectHTML(h.r)
+		if err != nil {
+			return 0, err
+		}
+		h.r = r
+	}
+
+	return h.r.Read(p)
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// bomStripper removes a UTF-8 byte order mark from the start of the reader. The
+// start is read in full before the first read returns, so a BOM split across
+// reads is still detected.
+type bomStripper struct {
+	r       io.Reader
+	checked bool
+}
+
+func (b *bomStripper) Read(p []byte) (int, error) {
+	if !b.checked {
+		b.checked = true
+
+		prefix := make([]byte, len(utf8BOM))
+		n, err := io.ReadFull(b.r, prefix)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, err
+		}
+		if !bytes.Equal(prefix[:n], utf8BOM) {
+			b.r = io.MultiReader(bytes.NewReader(prefix[:n]), b.r)
+		}
+	}
+
+	return b.r.Read(p)
+}
@@ -0,0 +1,223 @@
+package cargo
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// ErrStagingNotFile is the error returned when BeforeCopy, AfterCopy, or
+// ValidateStaged is set, and the download is staged using a StagingFactory that
+// doesn't create files.
+var ErrStagingNotFile = errors.New(`staged data isn't stored in a file`)
+
+// Staging is the storage a download is written to before it's copied into Dest.
+// Once the download completes, Close is called to release it, and then Cleanup
+// to discard the staged data.
+//
+// A Staging that also implements Truncate(size int64) error is reused between
+// retries. Otherwise it is cleaned up and a new one is created for each retry.
+type Staging interface {
+	io.ReadWriteSeeker
+	Close() error
+	Cleanup() error
+}
+
+// StagingFactory creates the Staging for a download. A parallel download
+// creates one for each range, so implementations must be safe for concurrent
+// use.
+type StagingFactory interface {
+	Create() (Staging, error)
+}
+
+// TempFileStaging is the default StagingFactory, staging each download in a
+// temporary file created in Dir. Pattern names the file as described for
+// DownloadInput.TempPattern.
+type TempFileStaging struct {
+	Dir     string
+	Pattern string
+}
+
+// Create implements StagingFactory.
+func (s TempFileStaging) Create() (Staging, error) {
+	pattern := s.Pattern
+	if pattern == "" {
+		pattern = defaultTempPattern
+	}
+	f, err := createTemp(s.Dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &tempFileStaging{f}, nil
+}
+
+// tempFileStaging is a temporary file, removed by Cleanup.
+type tempFileStaging struct {
+	*os.File
+}
+
+func (s *tempFileStaging) Cleanup() error {
+	return os.Remove(s.Name())
+}
+
+// callerFileStaging is the file given in DownloadInput.StagingFile. It is owned
+// by the caller, so it is never closed or removed.
+type callerFileStaging struct {
+	*os.File
+}
+
+func (s *callerFileStaging) Close() error {
+	return nil
+}
+
+func (s *callerFileStaging) Cleanup() error {
+	return nil
+}
+
+// MemoryStaging is a StagingFactory that stages each download in memory, for
+// environments without a writable disk. The complete file is held in memory
+// until the download returns, so it is only suitable for small files.
+type MemoryStaging struct{}
+
+// Create implements StagingFactory.
+func (MemoryStaging) Create() (Staging, error) {
+	return &memoryStaging{}, nil
+}
+
+// memoryStaging is an in-memory file.
+type memoryStaging struct {
+	data   []byte
+	offset int64
+}
+
+func (s *memoryStaging) Read(p []byte) (int, error) {
+	if s.offset >= int64(len(s.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.data[s.offset:])
+	s.offset += int64(n)
+	return n, nil
+}
+
+func (s *memoryStaging) Write(p []byte) (int, error) {
+	if end := s.offset + int64(len(p)); end > int64(len(s.data)) {
+		s.data = append(s.data, make([]byte, end-int64(len(s.data)))...)
+	}
+	n := copy(s.data[s.offset:], p)
+	s.offset += int64(n)
+	return n, nil
+}
+
+func (s *memoryStaging) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += int64(len(s.data))
+	}
+	if offset < 0 {
+		return 0, errors.New(`seek before the start of the staged data`)
+	}
+	s.offset = offset
+	return offset, nil
+}
+
+func (s *memoryStaging) Truncate(size int64) error {
+	if size < int64(len(s.data)) {
+		s.data = s.data[:size]
+	} else {
+		s.data = append(s.data, make([]byte, size-int64(len(s.data)))...)
+	}
+	return nil
+}
+
+func (s *memoryStaging) Close() error {
+	return nil
+}
+
+func (s *memoryStaging) Cleanup() error {
+	s.data = nil
+	return nil
+}
+
+// createStaging returns the Staging for a download: the caller's StagingFile if
+// one is set, or a new Staging from the input's StagingFactory.
+func createStaging(in DownloadInput) (Staging, error) {
+	if in.StagingFile != nil {
+		return &callerFileStaging{in.StagingFile}, nil
+	}
+	return in.StagingFactory.Create()
+}
+
+// releaseStaging closes the staging and discards its data.
+func releaseStaging(s Staging) {
+	s.Close()
+	s.Cleanup()
+}
+
+// truncater is implemented by a Staging that can be reused between retries.
+type truncater interface {
+	Truncate(size int64) error
+}
+
+// resetStaging discards the staged data so the download can be written again
+// from the start. A staging that can't be truncated is replaced by a new one.
+func resetStaging(in DownloadInput, s *Staging) error {
+	if t, ok := (*s).(truncater); ok {
+		if err := t.Truncate(0); err != nil {
+			return err
+		}
+		_, err := (*s).Seek(0, io.SeekStart)
+		return err
+	}
+
+	releaseStaging(*s)
+	next, err := createStaging(in)
+	if err != nil {
+		return err
+	}
+	*s = next
+	return nil
+}
+
+// truncateStaging discards the staged data past size, and positions the
+// staging at the end of the data that's kept. The staging must implement
+// truncater.
+func truncateStaging(s Staging, size int64) error {
+	if err := s.(truncater).Truncate(size); err != nil {
+		return err
+	}
+	_, err := s.Seek(size, io.SeekStart)
+	return err
+}
+
+// stagedFile returns the file the staging writes to, if it's a file.
+func stagedFile(s Staging) (*os.File, bool) {
+	switch s := s.(type) {
+	case *tempFileStaging:
+		return s.File, true
+	case *callerFileStaging:
+		return s.File, true
+	}
+	return nil, false
+}
+
+// stagingDest returns the writer a download is staged through. A staging that
+// is replaced between retries is written through a stagingWriter, while any
+// other staging is written directly so its ReadFrom can be used.
+func stagingDest(s *Staging) io.Writer {
+	if _, ok := (*s).(truncater); ok {
+		return *s
+	}
+	return stagingWriter{s}
+}
+
+// stagingWriter writes to the current staging of a download, which changes if
+// the staging is replaced between retries.
+type stagingWriter struct {
+	s *Staging
+}
+
+func (w stagingWriter) Write(b []byte) (int, error) {
+	return (*w.s).Write(b)
+}
@@ -0,0 +1,119 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fileOnlyStaging stages in a temporary file, but doesn't support Truncate, so
+// it is replaced between retries.
+type fileOnlyStaging struct {
+	f       *os.File
+	cleaned *int32
+}
+
+func (s *fileOnlyStaging) Read(p []byte) (int, error)  { return s.f.Read(p) }
+func (s *fileOnlyStaging) Write(p []byte) (int, error) { return s.f.Write(p) }
+func (s *fileOnlyStaging) Seek(offset int64, whence int) (int64, error) {
+	return s.f.Seek(offset, whence)
+}
+func (s *fileOnlyStaging) Close() error { return s.f.Close() }
+func (s *fileOnlyStaging) Cleanup() error {
+	atomic.AddInt32(s.cleaned, 1)
+	return os.Remove(s.f.Name())
+}
+
+type fileOnlyStagingFactory struct {
+	created, cleaned int32
+}
+
+func (f *fileOnlyStagingFactory) Create() (cargo.Staging, error) {
+	file, err := os.CreateTemp("", "cargo-test-*")
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt32(&f.created, 1)
+	return &fileOnlyStaging{f: file, cleaned: &f.cleaned}, nil
+}
+
+func TestDownloadStagingFactory(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Length`, `10`)
+		if atomic.AddInt32(&requests, 1) == 1 {
+			// The first response is cut short, so the attempt is retried after
+			// writing to the staging.
+			w.Write([]byte(`01234`))
+			return
+		}
+		w.Write([]byte(`0123456789`))
+	}))
+	defer server.Close()
+
+	source, _ := url.Parse(server.URL)
+
+	retry := &cargo.RetryPolicy{MaxAttempts: 2, Delay: time.Millisecond}
+
+	t.Run(`given memory staging`, func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+
+		var buf bytes.Buffer
+		out, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:         source,
+			Dest:           &buf,
+			Retry:          retry,
+			StagingFactory: cargo.MemoryStaging{},
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, out.Attempts)
+		assert.Equal(t, `0123456789`, buf.String())
+	})
+
+	t.Run(`given a staging that can't be truncated`, func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+
+		factory := &fileOnlyStagingFactory{}
+
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:         source,
+			Dest:           &buf,
+			Retry:          retry,
+			StagingFactory: factory,
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, `0123456789`, buf.String())
+		assert.Equal(t, int32(2), factory.created)
+		assert.Equal(t, int32(2), factory.cleaned)
+	})
+
+	t.Run(`given a hook requiring a file`, func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:         source,
+			Dest:           &buf,
+			StagingFactory: cargo.MemoryStaging{},
+			ValidateStaged: func(*os.File) error { return nil },
+		})
+
+		assert.True(t, errors.Is(err, cargo.ErrStagingNotFile))
+		assert.Equal(t, 0, buf.Len())
+	})
+}
@@ -0,0 +1,132 @@
+package cargo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// orphanedTempName matches the names of temporary files created with the
+// default TempPattern.
+var orphanedTempName = regexp.MustCompile(`^cargo-download-[0-9]+$`)
+
+// CleanupOrphanedTemp removes temporary files left in dir by downloads that
+// didn't complete, such as when the process crashed. Only regular files named
+// using the default TempPattern, and last modified more than olderThan ago, are
+// removed. Files using a custom TempPattern are never touched. If dir is empty,
+// the directory returned by os.TempDir is used.
+//
+// It returns the number of files removed. Files that can't be removed are
+// skipped, and the first error encountered is returned once the scan is done.
+func CleanupOrphanedTemp(dir string, olderThan time.Duration) (int, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+
+	var (
+		removed  int
+		firstErr error
+	)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !orphanedTempName.MatchString(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			// The file was removed since the directory was read.
+			continue
+		}
+		if !info.ModTime().Before(cutoff) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			if firstErr == nil && !os.IsNotExist(err) {
+				firstErr = err
+			}
+			continue
+		}
+		removed++
+	}
+
+	return removed, firstErr
+}
+
+// maxTempNameAttempts bounds the attempts made by createTemp to generate a
+// usable name.
+const maxTempNameAttempts = 10
+
+// createTemp creates a temporary file like os.CreateTemp, using a path that is
+// safe for the platform. On Windows a directory too deep for the legacy path
+// limit uses an extended-length path, and a generated name that is reserved for
+// a device is replaced. If every attempt generates a reserved name an error is
+// returned, rather than a name that was never reserved for the download.
+func createTemp(dir, pattern string) (*os.File, error) {
+	dir = tempDirPath(dir, pattern)
+
+	for attempt := 1; attempt <= maxTempNameAttempts; attempt++ {
+		f, err := os.CreateTemp(dir, pattern)
+		if err != nil || !isReservedTempName(f.Name()) {
+			return f, err
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	return nil, fmt.Errorf("failed to create a temporary file in %q: every generated name was reserved", dir)
+}
+
+// maxLegacyPath is the limit on the length of a Windows path that doesn't use
+// the extended-length prefix.
+const maxLegacyPath = 260
+
+// maxTempRandomLen is the longest random string os.CreateTemp puts in a name.
+const maxTempRandomLen = 10
+
+// extendedLengthPath returns the absolute Windows path with the "\\?\" prefix,
+// which lifts the legacy path limit. Paths using the prefix aren't normalized
+// by Windows, so any forward slashes are converted to backslashes.
+func extendedLengthPath(path string) string {
+	if strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+
+	path = strings.ReplaceAll(path, `/`, `\`)
+	if strings.HasPrefix(path, `\\`) {
+		// A UNC path, "\\server\share", becomes "\\?\UNC\server\share".
+		return `\\?\UNC\` + strings.TrimPrefix(path, `\\`)
+	}
+	return `\\?\` + path
+}
+
+// isWindowsReservedName reports if the file name is reserved for a device on
+// Windows, such as "CON" or "LPT1.txt". The check ignores case, any extension,
+// and trailing spaces or dots.
+func isWindowsReservedName(name string) bool {
+	if idx := strings.IndexByte(name, '.'); idx != -1 {
+		name = name[:idx]
+	}
+	name = strings.ToUpper(strings.TrimRight(name, ". "))
+
+	switch name {
+	case "CON", "PRN", "AUX", "NUL", "CONIN$", "CONOUT$":
+		return true
+	}
+
+	if len(name) == 4 && (strings.HasPrefix(name, "COM") || strings.HasPrefix(name, "LPT")) {
+		return name[3] >= '1' && name[3] <= '9'
+	}
+
+	return false
+}
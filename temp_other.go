@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package cargo
+
+// tempDirPath returns the directory to create a temporary file in. Only Windows
+// needs to adjust the path.
+func tempDirPath(dir, pattern string) string {
+	return dir
+}
+
+// isReservedTempName reports if the name can't be used for a file. Only Windows
+// reserves names.
+func isReservedTempName(path string) bool {
+	return false
+}
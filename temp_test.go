@@ -0,0 +1,42 @@
+package cargo_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanupOrphanedTemp(t *testing.T) {
+	dir := t.TempDir()
+
+	old := time.Now().Add(-2 * time.Hour)
+
+	create := func(name string, mtime time.Time) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(`data`), 0600))
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+		return path
+	}
+
+	orphaned := create(`cargo-download-123456`, old)
+	recent := create(`cargo-download-654321`, time.Now())
+	unrelated := create(`cargo-download-notes.txt`, old)
+	other := create(`report.csv`, old)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, `cargo-download-999`), 0700))
+
+	removed, err := cargo.CleanupOrphanedTemp(dir, time.Hour)
+
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, removed)
+	assert.NoFileExists(t, orphaned)
+	assert.FileExists(t, recent)
+	assert.FileExists(t, unrelated)
+	assert.FileExists(t, other)
+	assert.DirExists(t, filepath.Join(dir, `cargo-download-999`))
+}
@@ -0,0 +1,31 @@
+//go:build windows
+// +build windows
+
+package cargo
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// tempDirPath returns the directory to create a temporary file in, using an
+// extended-length path when the file's path could exceed the legacy limit.
+func tempDirPath(dir, pattern string) string {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	if len(abs)+1+len(pattern)+maxTempRandomLen < maxLegacyPath {
+		return dir
+	}
+
+	return extendedLengthPath(abs)
+}
+
+func isReservedTempName(path string) bool {
+	return isWindowsReservedName(filepath.Base(path))
+}
@@ -0,0 +1,123 @@
+package cargo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MinThroughput sets the lowest acceptable transfer rate for reading the
+// response body. If the average rate over the last Window drops below
+// BytesPerSecond the read is canceled, and the download fails with a
+// SlowDownloadError. A zero BytesPerSecond disables the check.
+type MinThroughput struct {
+	BytesPerSecond int64
+
+	// The duration the rate is averaged over. The check begins once the read
+	// has been running for the full window. If there is no window specified a
+	// value of 30 seconds will be used.
+	Window time.Duration
+}
+
+// SlowDownloadError is the error returned when a download's transfer rate drops
+// below the DownloadInput.MinThroughput.
+type SlowDownloadError struct {
+	BytesPerSecond    int64         // The measured rate
+	MinBytesPerSecond int64         // The required rate
+	Window            time.Duration // The duration the rate was measured over
+}
+
+func (e *SlowDownloadError) Error() string {
+	return fmt.Sprintf("download too slow (%d B/s over %s, minimum %d B/s)", e.BytesPerSecond, e.Window, e.MinBytesPerSecond)
+}
+
+type throughputSample struct {
+	at    time.Time
+	count int64
+}
+
+// throughputMonitor counts the bytes written to it, and cancels the read when
+// the rolling average rate drops below the minimum.
+type throughputMonitor struct {
+	min   MinThroughput
+	clock clock
+	count int64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	err  error
+}
+
+func startThroughputMonitor(min MinThroughput, c clock, cancel context.CancelFunc) *throughputMonitor {
+	if min.Window <= 0 {
+		min.Window = 30 * time.Second
+	}
+
+	m := &throughputMonitor{
+		min:   min,
+		clock: clockOrDefault(c),
+		done:  make(chan struct{}),
+	}
+
+	m.wg.Add(1)
+	go m.run(cancel)
+
+	return m
+}
+
+func (m *throughputMonitor) Write(b []byte) (int, error) {
+	atomic.AddInt64(&m.count, int64(len(b)))
+	return len(b), nil
+}
+
+func (m *throughputMonitor) run(cancel context.CancelFunc) {
+	defer m.wg.Done()
+
+	interval := m.min.Window / 10
+	timer := m.clock.NewTimer(interval)
+	defer func() { timer.Stop() }()
+
+	samples := []throughputSample{{at: m.clock.Now()}}
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-timer.C():
+			timer = m.clock.NewTimer(interval)
+
+			count := atomic.LoadInt64(&m.count)
+			samples = append(samples, throughputSample{at: now, count: count})
+
+			cutoff := now.Add(-m.min.Window)
+			if samples[0].at.After(cutoff) {
+				continue
+			}
+			for len(samples) > 1 && !samples[1].at.After(cutoff) {
+				samples = samples[1:]
+			}
+
+			elapsed := now.Sub(samples[0].at)
+			rate := int64(float64(count-samples[0].count) / elapsed.Seconds())
+			if rate < m.min.BytesPerSecond {
+				m.err = &SlowDownloadError{
+					BytesPerSecond:    rate,
+					MinBytesPerSecond: m.min.BytesPerSecond,
+					Window:            elapsed,
+				}
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// stop ends the monitoring, returning a SlowDownloadError if the read was
+// canceled for being too slow.
+func (m *throughputMonitor) stop() error {
+	close(m.done)
+	m.wg.Wait()
+	return m.err
+}
@@ -0,0 +1,64 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadMinThroughput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != `/slow` {
+			w.Write(bytes.Repeat([]byte(`0123456789`), 10000))
+			return
+		}
+		for i := 0; i < 100; i++ {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+			w.Write([]byte(`0123456789`))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	minThroughput := cargo.MinThroughput{BytesPerSecond: 100000, Window: 100 * time.Millisecond}
+
+	t.Run(`given a slow server`, func(t *testing.T) {
+		source, _ := url.Parse(server.URL + `/slow`)
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:        source,
+			Dest:          &bytes.Buffer{},
+			MinThroughput: minThroughput,
+		})
+
+		var slowErr *cargo.SlowDownloadError
+		require.True(t, errors.As(err, &slowErr))
+
+		assert.Less(t, slowErr.BytesPerSecond, int64(100000))
+	})
+
+	t.Run(`given a fast server`, func(t *testing.T) {
+		source, _ := url.Parse(server.URL)
+
+		_, err := cargo.Download(context.Background(), cargo.DownloadInput{
+			Source:        source,
+			Dest:          &bytes.Buffer{},
+			MinThroughput: minThroughput,
+		})
+
+		assert.NoError(t, err)
+	})
+}
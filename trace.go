@@ -0,0 +1,133 @@
+package cargo
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// remoteAddrKey is the context key for a request's remoteAddrRecorder.
+type remoteAddrKey struct{}
+
+// remoteAddrRecorder holds the remote address of the last connection used by a
+// request. A request's connections are obtained one at a time, including those
+// for redirects, so it doesn't need to be synchronized.
+type remoteAddrRecorder struct {
+	addr string
+}
+
+// traceRemoteAddr returns a copy of the request that records the remote address
+// of each connection it uses. Any ClientTrace already in the request's context
+// is still called.
+func traceRemoteAddr(req *http.Request) *http.Request {
+	rec := &remoteAddrRecorder{}
+
+	ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Conn != nil {
+				rec.addr = info.Conn.RemoteAddr().String()
+			}
+		},
+	})
+
+	return req.WithContext(context.WithValue(ctx, remoteAddrKey{}, rec))
+}
+
+// responseRemoteAddr returns the remote address of the connection the response
+// was received on, if it was recorded.
+func responseRemoteAddr(resp *http.Response) string {
+	if resp.Request == nil {
+		return ""
+	}
+	if rec, ok := resp.Request.Context().Value(remoteAddrKey{}).(*remoteAddrRecorder); ok {
+		return rec.addr
+	}
+	return ""
+}
+
+// DownloadTrace contains the timings of a request made by a download, collected
+// using httptrace. Timings for steps that didn't happen, such as the DNS lookup
+// and connect for a reused connection, are zero. When a request is redirected
+// the timings cover the final request.
+type DownloadTrace struct {
+	Method string   // The request's method, e.g. "GET", or "HEAD" for a probe
+	URL    *url.URL // The final URL of the request
+
+	DNSLookup       time.Duration // The time spent resolving the host name
+	Connect         time.Duration // The time spent establishing the TCP connection
+	TLSHandshake    time.Duration // The time spent on the TLS handshake
+	TimeToFirstByte time.Duration // The time from requesting a connection to the first response byte
+	Reused          bool          // True if the request reused an idle connection
+}
+
+// timingRecorder collects the timings of a request. The transport may call the
+// hooks from its dialing goroutines, so the recorder is synchronized.
+type timingRecorder struct {
+	mu    sync.Mutex
+	trace DownloadTrace
+
+	getConn, dnsStart, connectStart, tlsStart time.Time
+}
+
+// traceTimings returns a copy of the request that records its timings in the
+// returned recorder.
+func traceTimings(req *http.Request) (*http.Request, *timingRecorder) {
+	rec := &timingRecorder{}
+
+	since := func(start *time.Time, d *time.Duration) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		if !start.IsZero() {
+			*d = time.Since(*start)
+		}
+	}
+	mark := func(t *time.Time) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		*t = time.Now()
+	}
+
+	ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GetConn: func(string) {
+			rec.mu.Lock()
+			defer rec.mu.Unlock()
+			// A redirect starts a new request, so the previous timings are
+			// discarded.
+			rec.trace = DownloadTrace{}
+			rec.getConn = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			rec.mu.Lock()
+			defer rec.mu.Unlock()
+			rec.trace.Reused = info.Reused
+		},
+		DNSStart:             func(httptrace.DNSStartInfo) { mark(&rec.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { since(&rec.dnsStart, &rec.trace.DNSLookup) },
+		ConnectStart:         func(string, string) { mark(&rec.connectStart) },
+		ConnectDone:          func(string, string, error) { since(&rec.connectStart, &rec.trace.Connect) },
+		TLSHandshakeStart:    func() { mark(&rec.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { since(&rec.tlsStart, &rec.trace.TLSHandshake) },
+		GotFirstResponseByte: func() { since(&rec.getConn, &rec.trace.TimeToFirstByte) },
+	})
+
+	return req.WithContext(ctx), rec
+}
+
+// result returns the recorded timings for the response, or for the request if
+// no response was received.
+func (r *timingRecorder) result(req *http.Request, resp *http.Response) DownloadTrace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	trace := r.trace
+	trace.Method = req.Method
+	trace.URL = req.URL
+	if resp != nil && resp.Request != nil {
+		trace.URL = resp.Request.URL
+	}
+	return trace
+}
@@ -0,0 +1,60 @@
+package cargo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// TruncatedError is the error returned when the connection ends before the
+// complete response body was received. For a chunked response this means the
+// body ended before its terminating zero-length chunk, and for a response with
+// a Content-Length it means fewer bytes than announced were received.
+type TruncatedError struct {
+	Received int64 // The number of bytes received before the body ended
+	Expected int64 // The expected size, or -1 for a chunked response
+}
+
+func (e *TruncatedError) Error() string {
+	if e.Expected < 0 {
+		return fmt.Sprintf("response body truncated after %d bytes", e.Received)
+	}
+	return fmt.Sprintf("response body truncated after %d of %d bytes", e.Received, e.Expected)
+}
+
+// Unwrap returns io.ErrUnexpectedEOF, so the error can still be checked with
+// errors.Is.
+func (e *TruncatedError) Unwrap() error {
+	return io.ErrUnexpectedEOF
+}
+
+// truncationReader reads a response body, and turns the unexpected EOF
+// reported by the transport for a body that ended early into a
+// *TruncatedError. A clean io.EOF is passed through unchanged.
+type truncationReader struct {
+	r        io.Reader
+	offset   int64
+	expected int64
+	n        int64
+}
+
+// detectTruncation wraps the response's body in a truncationReader. The offset
+// is the number of bytes of the file received before this response, which is
+// included in the sizes reported by the error.
+func detectTruncation(resp *http.Response, offset int64) io.Reader {
+	expected := int64(-1)
+	if resp.ContentLength >= 0 {
+		expected = offset + resp.ContentLength
+	}
+	return &truncationReader{r: resp.Body, offset: offset, expected: expected}
+}
+
+func (t *truncationReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = &TruncatedError{Received: t.offset + t.n, Expected: t.expected}
+	}
+	return n, err
+}
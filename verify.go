@@ -0,0 +1,177 @@
+package cargo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ErrChecksumNotFound is the error returned by DownloadVerified when the
+// checksum file doesn't contain an entry for the downloaded file.
+var ErrChecksumNotFound = errors.New(`checksum not found`)
+
+// DownloadVerified downloads the checksum file at sumsURL, finds the digest for
+// the file at fileURL, and then downloads the file to dest, verifying it against
+// that digest.
+//
+// The checksum file uses the format produced by tools like sha256sum, with one
+// "<hex digest>  <filename>" entry per line. The entry is matched using the
+// last segment of fileURL's path, and the hash algorithm is chosen from the
+// digest's length (SHA-1, SHA-256, or SHA-512). If there is no matching entry
+// ErrChecksumNotFound is returned, and a digest mismatch returns a
+// *ChecksumError without writing anything to dest.
+//
+// The options are applied to the file's download. The checksum file is read
+// into memory using only the options that describe how to reach the server:
+// the HTTPClient and its transport settings, MaxRedirects and
+// PreserveAuthOnRedirect, the CreateRequest, Header, BasicAuth, BearerToken,
+// and netrc, the OnRequest, OnResponse, and TraceHandler hooks, the Retry
+// policy, the Deadline, AttemptTimeout, ReadTimeout, and CopyTimeout, the
+// ErrorBodyLimit, and the Logger. Options that describe the file, like
+// ExpectedSize, MaxBytes, ResumeFrom, or ValidateResponse, only apply to the
+// file. The checksum file's response must have a 2xx status code, otherwise an
+// *HTTPResponseError is returned rather than parsing the body.
+func DownloadVerified(ctx context.Context, fileURL, sumsURL *url.URL, dest io.Writer, opts ...Option) (*DownloadOutput, error) {
+	if fileURL == nil || sumsURL == nil {
+		return nil, ErrMissingSource
+	}
+
+	var sums bytes.Buffer
+
+	sumsIn := checksumFileInput(opts)
+	sumsIn.Source = sumsURL
+	sumsIn.Dest = &sums
+
+	if _, err := Download(ctx, sumsIn); err != nil {
+		return nil, fmt.Errorf("failed to download checksum file: %w", err)
+	}
+
+	name := path.Base(fileURL.Path)
+
+	digest, err := findChecksum(&sums, name)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := hashForDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	in := DownloadInput{}
+	for _, opt := range opts {
+		opt(&in)
+	}
+	in.Source = fileURL
+	in.Dest = dest
+	in.Sink = nil
+	in.ChecksumWriter = h
+	in.ExpectedChecksum = digest
+
+	return Download(ctx, in)
+}
+
+// checksumFileInput returns the input for downloading a checksum file, with
+// only the options for reaching the server copied from opts.
+func checksumFileInput(opts []Option) DownloadInput {
+	var in DownloadInput
+	for _, opt := range opts {
+		opt(&in)
+	}
+
+	return DownloadInput{
+		HTTPClient:             in.HTTPClient,
+		DialTimeout:            in.DialTimeout,
+		DialContext:            in.DialContext,
+		TLSConfig:              in.TLSConfig,
+		ForceHTTP2:             in.ForceHTTP2,
+		SOCKS5:                 in.SOCKS5,
+		MaxIdleConns:           in.MaxIdleConns,
+		MaxIdleConnsPerHost:    in.MaxIdleConnsPerHost,
+		IdleConnTimeout:        in.IdleConnTimeout,
+		MaxRedirects:           in.MaxRedirects,
+		PreserveAuthOnRedirect: in.PreserveAuthOnRedirect,
+		CreateRequest:          in.CreateRequest,
+		Header:                 in.Header,
+		BasicAuth:              in.BasicAuth,
+		BearerToken:            in.BearerToken,
+		UseNetrc:               in.UseNetrc,
+		NetrcPath:              in.NetrcPath,
+		OnRequest:              in.OnRequest,
+		OnResponse:             in.OnResponse,
+		TraceHandler:           in.TraceHandler,
+		ValidateResponse:       validateSuccessStatus,
+		ErrorBodyLimit:         in.ErrorBodyLimit,
+		Retry:                  in.Retry,
+		Deadline:               in.Deadline,
+		AttemptTimeout:         in.AttemptTimeout,
+		ReadTimeout:            in.ReadTimeout,
+		CopyTimeout:            in.CopyTimeout,
+		Logger:                 in.Logger,
+	}
+}
+
+// validateSuccessStatus returns an *HTTPResponseError for a response without a
+// 2xx status code, such as an HTML error page served for a missing checksum
+// file.
+func validateSuccessStatus(r *http.Response) error {
+	if r.StatusCode >= 200 && r.StatusCode < 300 {
+		return nil
+	}
+	return &HTTPResponseError{StatusCode: r.StatusCode, Status: r.Status}
+}
+
+// findChecksum returns the digest for the named file from a checksum file.
+// Names are compared using their last path segment, and the "*" prefix used to
+// mark binary mode entries is ignored.
+func findChecksum(r io.Reader, name string) ([]byte, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		entry := strings.TrimPrefix(fields[1], "*")
+		if path.Base(entry) != name {
+			continue
+		}
+
+		digest, err := hex.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid checksum for %q: %w", name, err)
+		}
+		return digest, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("%w for %q", ErrChecksumNotFound, name)
+}
+
+// hashForDigest returns a hash producing digests of the same length as digest.
+func hashForDigest(digest []byte) (hash.Hash, error) {
+	switch len(digest) {
+	case sha1.Size:
+		return sha1.New(), nil
+	case sha256.Size:
+		return sha256.New(), nil
+	case sha512.Size:
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum length: %d bytes", len(digest))
+	}
+}
@@ -0,0 +1,116 @@
+package cargo_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/maddiesch/go-cargo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadVerified(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 10000)
+	digest := sha256.Sum256(data)
+
+	sums := fmt.Sprintf("%x  other.tar.gz\n%s *release/app.tar.gz\n%x  corrupt.tar.gz\n", sha256.Sum256(nil), hex.EncodeToString(digest[:]), sha256.Sum256(nil))
+
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get(`X-Token`))
+
+		switch r.URL.Path {
+		case `/SHA256SUMS`:
+			w.Write([]byte(sums))
+		case `/app.tar.gz`, `/corrupt.tar.gz`, `/missing.tar.gz`:
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	sumsURL, _ := url.Parse(server.URL + `/SHA256SUMS`)
+
+	download := func(path string, opts ...cargo.Option) (*cargo.DownloadOutput, *bytes.Buffer, error) {
+		fileURL, _ := url.Parse(server.URL + path)
+
+		var buf bytes.Buffer
+		out, err := cargo.DownloadVerified(context.Background(), fileURL, sumsURL, &buf, opts...)
+
+		return out, &buf, err
+	}
+
+	t.Run(`given a matching digest`, func(t *testing.T) {
+		out, buf, err := download(`/app.tar.gz`)
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+		assert.Equal(t, digest[:], out.Checksum)
+	})
+
+	t.Run(`given a mismatched digest`, func(t *testing.T) {
+		_, buf, err := download(`/corrupt.tar.gz`)
+
+		var checksumErr *cargo.ChecksumError
+		require.True(t, errors.As(err, &checksumErr))
+
+		assert.Equal(t, `sha256`, checksumErr.Algorithm)
+		assert.Equal(t, digest[:], checksumErr.Actual)
+		assert.Equal(t, 0, buf.Len())
+	})
+
+	t.Run(`given a file missing from the checksums`, func(t *testing.T) {
+		_, buf, err := download(`/missing.tar.gz`)
+
+		assert.ErrorIs(t, err, cargo.ErrChecksumNotFound)
+		assert.Equal(t, 0, buf.Len())
+	})
+
+	t.Run(`given a missing checksum file`, func(t *testing.T) {
+		fileURL, _ := url.Parse(server.URL + `/app.tar.gz`)
+		missingURL, _ := url.Parse(server.URL + `/missing/SHA256SUMS`)
+
+		var statuses []int
+
+		var buf bytes.Buffer
+		_, err := cargo.DownloadVerified(context.Background(), fileURL, missingURL, &buf, func(in *cargo.DownloadInput) {
+			in.OnResponse = func(r *http.Response) {
+				statuses = append(statuses, r.StatusCode)
+			}
+		})
+
+		var respErr *cargo.HTTPResponseError
+		require.True(t, errors.As(err, &respErr))
+
+		assert.Equal(t, http.StatusNotFound, respErr.StatusCode)
+		assert.NotErrorIs(t, err, cargo.ErrChecksumNotFound)
+		assert.Equal(t, []int{http.StatusNotFound}, statuses)
+		assert.Equal(t, 0, buf.Len())
+	})
+
+	t.Run(`given options describing the file`, func(t *testing.T) {
+		tokens = nil
+
+		out, buf, err := download(`/app.tar.gz`, func(in *cargo.DownloadInput) {
+			in.ExpectedSize = int64(len(data))
+			in.MinBytes = int64(len(data))
+			in.Header = http.Header{`X-Token`: {`secret`}}
+		})
+
+		require.NoError(t, err)
+
+		assert.Equal(t, data, buf.Bytes())
+		assert.Equal(t, int64(len(data)), out.FileSize)
+		assert.Equal(t, []string{`secret`, `secret`}, tokens)
+	})
+}
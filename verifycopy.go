@@ -0,0 +1,64 @@
+package cargo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+)
+
+// CopyVerificationError is the error returned when DownloadInput.VerifyCopy is
+// set, and the data read back from Dest doesn't match the staged data.
+type CopyVerificationError struct {
+	Path     string // The name of the Dest file
+	Expected []byte // The SHA-256 digest of the staged data
+	Actual   []byte // The SHA-256 digest of the data read back from Dest
+}
+
+func (e *CopyVerificationError) Error() string {
+	return fmt.Sprintf("copy verification failed for %s: expected %x, got %x", e.Path, e.Expected, e.Actual)
+}
+
+// verifyCopy compares the size bytes staged in src with the last size bytes
+// written to dest, which end at dest's current offset. Dest is read using a
+// separate handle, so it works for files opened write-only.
+func verifyCopy(ctx context.Context, dest *os.File, src io.ReadSeeker, size int64) error {
+	end, err := dest.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	expected, err := sha256Reader(ctx, io.LimitReader(src, size))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(dest.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	actual, err := sha256Reader(ctx, io.NewSectionReader(f, end-size, size))
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(expected, actual) {
+		return &CopyVerificationError{Path: dest.Name(), Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+func sha256Reader(ctx context.Context, r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	if _, err := copyWithContext(ctx, h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}